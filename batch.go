@@ -0,0 +1,94 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"sync"
+)
+
+// Options that control how GetMany fetches its keys.
+type GetManyOptions struct {
+	// The number of requests that will be in flight at any one time. If this
+	// is zero then the client's Concurrency setting is used.
+	Concurrency int
+}
+
+// The outcome of fetching a single key as part of GetMany.
+type GetManyResult struct {
+	// The key that was requested.
+	Key string
+
+	// The value held at the key. This is nil if the key does not exist or
+	// if the request failed.
+	Result *KVResult
+
+	// Any error encountered fetching the key. A key that does not exist is
+	// not considered an error, in that case both Result and Err are nil.
+	Err error
+}
+
+// Get the values of many keys in a collection at once. The requests are
+// spread over a bounded number of concurrent connections and the results
+// are returned in the same order as the given keys.
+func (c *Client) GetMany(collection string, keys []string, opts *GetManyOptions) []GetManyResult {
+	concurrency := c.concurrency()
+	if opts != nil && opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+
+	results := make([]GetManyResult, len(keys))
+	parallel(len(keys), concurrency, func(i int) {
+		results[i].Key = keys[i]
+		result, err := c.Get(collection, keys[i])
+		if hasStatus(err, 404) {
+			return
+		}
+		results[i].Result = result
+		results[i].Err = err
+	})
+
+	return results
+}
+
+// Check if the key existed when it was fetched.
+func (r *GetManyResult) Found() bool {
+	return r.Result != nil
+}
+
+// Calls fn once for each index in [0, n) using at most concurrency
+// goroutines, returning once every call has completed.
+func parallel(n, concurrency int, fn func(i int)) {
+	if concurrency > n {
+		concurrency = n
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	for _, concurrency := range []int{1, 3, 100} {
+		seen := make([]int32, 50)
+		running, peak := int32(0), int32(0)
+		parallel(len(seen), concurrency, func(i int) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			atomic.AddInt32(&seen[i], 1)
+			atomic.AddInt32(&running, -1)
+		})
+
+		for i, count := range seen {
+			if count != 1 {
+				t.Errorf("concurrency %d: index %d called %d times", concurrency, i, count)
+			}
+		}
+		if int(peak) > concurrency {
+			t.Errorf("concurrency %d: %d calls ran at once", concurrency, peak)
+		}
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/broken"):
+			fake.fail(w, 500)
+		case strings.HasSuffix(r.URL.Path, "/0"):
+			// The first key answers last.
+			time.Sleep(20 * time.Millisecond)
+			fake.ServeHTTP(w, r)
+		default:
+			fake.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var keys []string
+	for i := 0; i < 10; i++ {
+		keys = append(keys, strconv.Itoa(i))
+		fake.Write("users", keys[i], `"value `+keys[i]+`"`, time.Now())
+	}
+	keys = append(keys, "missing", "broken")
+
+	results := c.GetMany("users", keys, &GetManyOptions{Concurrency: 4})
+	if len(results) != len(keys) {
+		t.Fatalf("GetMany() returned %d results for %d keys", len(results), len(keys))
+	}
+	for i, result := range results[:10] {
+		var value string
+		if result.Key != keys[i] || !result.Found() || result.Err != nil {
+			t.Errorf("Result %d = %+v", i, result)
+		} else if result.Result.Value(&value); value != "value "+keys[i] {
+			t.Errorf("Result %d holds %q", i, value)
+		}
+	}
+
+	if missing := results[10]; missing.Key != "missing" || missing.Found() || missing.Err != nil {
+		t.Errorf("Missing key = %+v, expected not found without an error", missing)
+	}
+	if broken := results[11]; broken.Key != "broken" || broken.Found() || !hasStatus(broken.Err, 500) {
+		t.Errorf("Broken key = %+v, expected its error", broken)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Returned when one direction of a bidirectional relationship could not be
+// written and undoing the other direction failed as well, leaving only half
+// of the pair in place.
+type PartialRelationError struct {
+	// The error writing the second direction.
+	Err error
+
+	// The error undoing the first direction.
+	CompensationErr error
+
+	// The direction that was left in place.
+	Remaining RelationPath
+}
+
+// A relationship that has no matching relationship in the other direction.
+type MissingReverseRelation struct {
+	// The relationship that exists.
+	Forward RelationPath
+
+	// Whether the reverse relationship was created.
+	Repaired bool
+
+	// The error creating the reverse relationship, if any.
+	Err error
+}
+
+// The result of checking a collection's relationships for missing reverse
+// relationships.
+type RelationCheckReport struct {
+	// The number of relationships examined.
+	Checked uint64
+
+	// The relationships with no reverse.
+	Missing []MissingReverseRelation
+}
+
+// Create a relationship of a specified type in both directions between two
+// collection-keys. Directions that already exist are left as they are, along
+// with their properties. If the second direction can not be written the
+// first is removed again, if this call created it, so that a failure never
+// leaves half of a new pair behind.
+func (c *Client) PutBidirectionalRelation(aCollection, aKey, kind, bCollection, bKey string) error {
+	created, err := c.putRelationIfMissing(aCollection, aKey, kind, bCollection, bKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.putRelationIfMissing(bCollection, bKey, kind, aCollection, aKey)
+	if err == nil || !created {
+		return err
+	}
+
+	if cerr := c.DeleteRelation(aCollection, aKey, kind, bCollection, bKey); cerr != nil {
+		return &PartialRelationError{
+			Err:             err,
+			CompensationErr: cerr,
+			Remaining:       *newRelationPath(aCollection, aKey, kind, bCollection, bKey),
+		}
+	}
+	return err
+}
+
+// Delete a relationship of a specified type in both directions between two
+// collection-keys. If the second direction can not be deleted the first is
+// restored along with its properties, if it existed, so that a failure never
+// leaves half of the pair behind.
+func (c *Client) DeleteBidirectionalRelation(aCollection, aKey, kind, bCollection, bKey string) error {
+	existing, err := c.GetRelation(aCollection, aKey, kind, bCollection, bKey)
+	if hasStatus(err, 404) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := c.DeleteRelation(aCollection, aKey, kind, bCollection, bKey); err != nil {
+		return err
+	}
+
+	err = c.DeleteRelation(bCollection, bKey, kind, aCollection, aKey)
+	if err == nil || existing == nil {
+		return err
+	}
+
+	var properties io.Reader
+	if len(existing.RawValue) > 0 {
+		properties = bytes.NewReader(existing.RawValue)
+	}
+	_, cerr := c.PutRelationWithPropertiesRaw(aCollection, aKey, kind, bCollection, bKey, properties)
+	if cerr != nil {
+		return &PartialRelationError{
+			Err:             err,
+			CompensationErr: cerr,
+			Remaining:       *newRelationPath(bCollection, bKey, kind, aCollection, aKey),
+		}
+	}
+	return err
+}
+
+// Creates a relationship unless it already exists, returning true if it was
+// created.
+func (c *Client) putRelationIfMissing(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) (bool, error) {
+	_, err := c.PutRelationIfAbsentRaw(sourceCollection, sourceKey, kind, sinkCollection, sinkKey, nil)
+	if hasStatus(err, 412) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Check every relationship of a specified type from the items in a
+// collection for a matching relationship in the other direction. If repair
+// is true any missing reverse relationships are created.
+func (c *Client) CheckReverseRelations(collection, kind string, repair bool) (*RelationCheckReport, error) {
+	report := &RelationCheckReport{}
+
+	results, err := c.List(collection, 100)
+	for {
+		if err != nil {
+			return report, err
+		}
+
+		for _, item := range results.Results {
+			if err := c.checkReverseRelations(&item.Path, kind, repair, report); err != nil {
+				return report, err
+			}
+		}
+
+		if !results.HasNext() {
+			return report, nil
+		}
+		results, err = c.ListGetNext(results)
+	}
+}
+
+// Checks the relationships of a single item.
+func (c *Client) checkReverseRelations(path *Path, kind string, repair bool, report *RelationCheckReport) error {
+	iter := c.IterateRelations(path.Collection, path.Key, []string{kind}, 100)
+	for iter.Next() {
+		sink := iter.Result().Path
+		report.Checked++
+
+		_, err := c.GetRelation(sink.Collection, sink.Key, kind, path.Collection, path.Key)
+		if err == nil {
+			continue
+		} else if !hasStatus(err, 404) {
+			return err
+		}
+
+		missing := MissingReverseRelation{
+			Forward: *newRelationPath(path.Collection, path.Key, kind, sink.Collection, sink.Key),
+		}
+		if repair {
+			missing.Err = c.PutRelation(sink.Collection, sink.Key, kind, path.Collection, path.Key)
+			missing.Repaired = missing.Err == nil
+		}
+		report.Missing = append(report.Missing, missing)
+	}
+	return iter.Err()
+}
+
+// Convert the error to a meaningful string.
+func (e *PartialRelationError) Error() string {
+	return fmt.Sprintf("%s; undoing it failed, leaving %s/%s -[%s]-> %s/%s: %s",
+		e.Err, e.Remaining.Source.Collection, e.Remaining.Source.Key, e.Remaining.Kind,
+		e.Remaining.Destination.Collection, e.Remaining.Destination.Key, e.CompensationErr)
+}
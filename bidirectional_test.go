@@ -0,0 +1,142 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// An in memory store of relationships, keyed by the path after /v0/, that
+// fails writes to the paths in failing.
+type fakeRelations struct {
+	lock    sync.Mutex
+	edges   map[string]string
+	failing map[string]bool
+}
+
+func (f *fakeRelations) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id := strings.TrimPrefix(r.URL.Path, "/v0/")
+	if r.Method != "GET" && f.failing[id] {
+		w.WriteHeader(500)
+		w.Write([]byte(`{"message": "broken"}`))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		value, ok := f.edges[id]
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message": "not found"}`))
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(value))
+	case "PUT":
+		if _, ok := f.edges[id]; ok && r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(412)
+			w.Write([]byte(`{"message": "exists"}`))
+			return
+		}
+		value, _ := ioutil.ReadAll(r.Body)
+		f.edges[id] = string(value)
+		w.WriteHeader(204)
+	case "DELETE":
+		delete(f.edges, id)
+		w.WriteHeader(204)
+	}
+}
+
+// Returns the stored properties of a relationship and whether it exists.
+func (f *fakeRelations) edge(id string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	value, ok := f.edges[id]
+	return value, ok
+}
+
+const (
+	forwardEdge = "users/a/relation/friend/users/b"
+	reverseEdge = "users/b/relation/friend/users/a"
+)
+
+func TestPutBidirectionalRelation(t *testing.T) {
+	fake := &fakeRelations{edges: map[string]string{}, failing: map[string]bool{}}
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	if err := c.PutBidirectionalRelation("users", "a", "friend", "users", "b"); err != nil {
+		t.Fatal(err)
+	}
+	_, forward := fake.edge(forwardEdge)
+	_, reverse := fake.edge(reverseEdge)
+	if !forward || !reverse {
+		t.Errorf("Relations after put: forward %v, reverse %v", forward, reverse)
+	}
+}
+
+func TestPutBidirectionalRelationUndo(t *testing.T) {
+	fake := &fakeRelations{edges: map[string]string{}, failing: map[string]bool{reverseEdge: true}}
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	// A forward relation created by the call is removed again.
+	if err := c.PutBidirectionalRelation("users", "a", "friend", "users", "b"); !hasStatus(err, 500) {
+		t.Errorf("PutBidirectionalRelation() = %v, expected the reverse failure", err)
+	}
+	if _, ok := fake.edge(forwardEdge); ok {
+		t.Error("The forward relation created by the failed put was left behind")
+	}
+
+	// A forward relation that already existed is left alone.
+	fake.edges[forwardEdge] = `{"since":2014}`
+	if err := c.PutBidirectionalRelation("users", "a", "friend", "users", "b"); !hasStatus(err, 500) {
+		t.Errorf("PutBidirectionalRelation() = %v, expected the reverse failure", err)
+	}
+	if value, ok := fake.edge(forwardEdge); !ok || value != `{"since":2014}` {
+		t.Errorf("The existing forward relation was changed to %q, %v", value, ok)
+	}
+}
+
+func TestDeleteBidirectionalRelationRestore(t *testing.T) {
+	fake := &fakeRelations{
+		edges:   map[string]string{forwardEdge: `{"since":2014}`, reverseEdge: `{}`},
+		failing: map[string]bool{reverseEdge: true},
+	}
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	if err := c.DeleteBidirectionalRelation("users", "a", "friend", "users", "b"); !hasStatus(err, 500) {
+		t.Errorf("DeleteBidirectionalRelation() = %v, expected the reverse failure", err)
+	}
+	if value, ok := fake.edge(forwardEdge); !ok || value != `{"since":2014}` {
+		t.Errorf("The forward relation was restored as %q, %v", value, ok)
+	}
+
+	delete(fake.failing, reverseEdge)
+	if err := c.DeleteBidirectionalRelation("users", "a", "friend", "users", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.edges) != 0 {
+		t.Errorf("Relations left after delete: %v", fake.edges)
+	}
+}
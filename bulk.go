@@ -0,0 +1,258 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned when adding items to a BulkWriter that has been closed.
+var ErrBulkWriterClosed = errors.New("gorc: bulk writer is closed")
+
+// The kind of operation performed by a BulkItem.
+type BulkOp int
+
+const (
+	// Store Value at Collection/Key.
+	BulkPut BulkOp = iota
+
+	// Apply Patch to the value at Collection/Key.
+	BulkPatch
+
+	// Delete the value at Collection/Key.
+	BulkDelete
+
+	// Add an event of type Kind holding Value to Collection/Key.
+	BulkEvent
+
+	// Relate Collection/Key to SinkCollection/SinkKey with the type Kind.
+	BulkRelation
+)
+
+// A single write queued on a BulkWriter. Which fields are used depends on
+// the Op being performed.
+type BulkItem struct {
+	Op         BulkOp
+	Collection string
+	Key        string
+
+	// The event or relation type for BulkEvent and BulkRelation items.
+	Kind string
+
+	// The value to store for BulkPut and BulkEvent items.
+	Value interface{}
+
+	// The operations to apply for BulkPatch items.
+	Patch PatchSet
+
+	// The time of a BulkEvent in milliseconds since the epoch. If this is
+	// zero the server assigns the current time.
+	Timestamp int64
+
+	// The destination of a BulkRelation.
+	SinkCollection string
+	SinkKey        string
+}
+
+// An item that could not be written along with the reason. The item holds
+// the original payload so that it can be added to a new writer and retried.
+type BulkFailure struct {
+	Item BulkItem
+	Err  error
+}
+
+// Progress counters for a BulkWriter.
+type BulkStats struct {
+	// The number of items accepted by the writer.
+	Queued uint64
+
+	// The number of items written successfully.
+	Succeeded uint64
+
+	// The number of items that failed to be written.
+	Failed uint64
+}
+
+// Writes items to Orchestrate using a pool of workers. Adding an item blocks
+// while the queue is full, so a fast producer is held back to the rate at
+// which the workers can write. Failed items are collected rather than
+// stopping the writer.
+type BulkWriter struct {
+	client *Client
+	queue  chan BulkItem
+	wg     sync.WaitGroup
+
+	// Guards closed, held for reading while items are being queued.
+	lock   sync.RWMutex
+	closed bool
+
+	failureLock sync.Mutex
+	failures    []BulkFailure
+
+	queued    uint64
+	succeeded uint64
+	failed    uint64
+}
+
+// Returns a new BulkWriter that writes with the given number of workers and
+// buffers up to queueSize items. If workers is zero or less then the client's
+// concurrency is used, and if queueSize is zero or less then it matches the
+// number of workers. Close must be called to wait for the queue to drain.
+func (c *Client) NewBulkWriter(workers, queueSize int) *BulkWriter {
+	if workers <= 0 {
+		workers = c.concurrency()
+	}
+	if queueSize <= 0 {
+		queueSize = workers
+	}
+
+	w := &BulkWriter{
+		client: c,
+		queue:  make(chan BulkItem, queueSize),
+	}
+
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go w.work()
+	}
+
+	return w
+}
+
+// Queue an item to be written, blocking while the queue is full.
+func (w *BulkWriter) Add(item BulkItem) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return ErrBulkWriterClosed
+	}
+
+	atomic.AddUint64(&w.queued, 1)
+	w.queue <- item
+	return nil
+}
+
+// Queue a value to be stored at a collection-key pair.
+func (w *BulkWriter) Put(collection, key string, value interface{}) error {
+	return w.Add(BulkItem{Op: BulkPut, Collection: collection, Key: key, Value: value})
+}
+
+// Queue a set of patch operations for a collection-key pair.
+func (w *BulkWriter) Patch(collection, key string, patch PatchSet) error {
+	return w.Add(BulkItem{Op: BulkPatch, Collection: collection, Key: key, Patch: patch})
+}
+
+// Queue the deletion of the value at a collection-key pair.
+func (w *BulkWriter) Delete(collection, key string) error {
+	return w.Add(BulkItem{Op: BulkDelete, Collection: collection, Key: key})
+}
+
+// Queue an event of the specified type for a collection-key pair.
+func (w *BulkWriter) PutEvent(collection, key, kind string, value interface{}) error {
+	return w.Add(BulkItem{Op: BulkEvent, Collection: collection, Key: key, Kind: kind, Value: value})
+}
+
+// Queue a relationship of the specified type between two collection-keys.
+func (w *BulkWriter) PutRelation(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) error {
+	return w.Add(BulkItem{
+		Op:             BulkRelation,
+		Collection:     sourceCollection,
+		Key:            sourceKey,
+		Kind:           kind,
+		SinkCollection: sinkCollection,
+		SinkKey:        sinkKey,
+	})
+}
+
+// Get the current progress counters.
+func (w *BulkWriter) Stats() BulkStats {
+	return BulkStats{
+		Queued:    atomic.LoadUint64(&w.queued),
+		Succeeded: atomic.LoadUint64(&w.succeeded),
+		Failed:    atomic.LoadUint64(&w.failed),
+	}
+}
+
+// Get the items that have failed so far.
+func (w *BulkWriter) Failures() []BulkFailure {
+	w.failureLock.Lock()
+	defer w.failureLock.Unlock()
+
+	failures := make([]BulkFailure, len(w.failures))
+	copy(failures, w.failures)
+	return failures
+}
+
+// Stop accepting new items and wait for everything queued to be written.
+// This returns every item that failed.
+func (w *BulkWriter) Close() []BulkFailure {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.lock.Unlock()
+
+	w.wg.Wait()
+	return w.Failures()
+}
+
+// Processes items from the queue until it is closed.
+func (w *BulkWriter) work() {
+	defer w.wg.Done()
+
+	for item := range w.queue {
+		if err := w.write(&item); err != nil {
+			atomic.AddUint64(&w.failed, 1)
+			w.failureLock.Lock()
+			w.failures = append(w.failures, BulkFailure{Item: item, Err: err})
+			w.failureLock.Unlock()
+		} else {
+			atomic.AddUint64(&w.succeeded, 1)
+		}
+	}
+}
+
+// Performs the write for a single item.
+func (w *BulkWriter) write(item *BulkItem) error {
+	c := w.client
+
+	switch item.Op {
+	case BulkPut:
+		_, err := c.Put(item.Collection, item.Key, item.Value)
+		return err
+	case BulkPatch:
+		_, err := c.Patch(item.Collection, item.Key, item.Patch)
+		return err
+	case BulkDelete:
+		return c.Delete(item.Collection, item.Key)
+	case BulkEvent:
+		var err error
+		if item.Timestamp != 0 {
+			_, err = c.PutEventWithTime(item.Collection, item.Key, item.Kind, item.Timestamp, item.Value)
+		} else {
+			_, err = c.PutEvent(item.Collection, item.Key, item.Kind, item.Value)
+		}
+		return err
+	case BulkRelation:
+		return c.PutRelation(item.Collection, item.Key, item.Kind, item.SinkCollection, item.SinkKey)
+	}
+
+	return fmt.Errorf("Unknown bulk operation: %d", item.Op)
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a client that sends every request to handler.
+func newTestClient(handler http.Handler) (*Client, *httptest.Server) {
+	server := httptest.NewTLSServer(handler)
+	c := NewClient("test")
+	c.APIHost = strings.TrimPrefix(server.URL, "https://")
+	c.HTTPClient = server.Client()
+	return c, server
+}
+
+// Answers a put with a created response holding the given ref.
+func writeCreated(w http.ResponseWriter, r *http.Request, ref string) {
+	w.Header().Set("Location", r.URL.Path+"/refs/"+ref)
+	w.Header().Set("ETag", `"`+ref+`"`)
+	w.WriteHeader(201)
+}
+
+func TestBulkWriterFailures(t *testing.T) {
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/bad") {
+			w.WriteHeader(500)
+			w.Write([]byte(`{"message": "broken"}`))
+			return
+		}
+		writeCreated(w, r, "abc")
+	}))
+	defer server.Close()
+
+	writer := c.NewBulkWriter(3, 0)
+	for _, key := range []string{"a", "bad", "b", "c"} {
+		if err := writer.Put("users", key, map[string]string{"name": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failures := writer.Close()
+	if len(failures) != 1 || failures[0].Item.Key != "bad" {
+		t.Fatalf("Close() = %+v, expected the bad key to fail", failures)
+	}
+	if !hasStatus(failures[0].Err, 500) {
+		t.Errorf("Failure error = %v, expected a 500", failures[0].Err)
+	}
+	if stats := writer.Stats(); stats != (BulkStats{Queued: 4, Succeeded: 3, Failed: 1}) {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestBulkWriterBackpressure(t *testing.T) {
+	started := make(chan bool, 10)
+	release := make(chan bool)
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		writeCreated(w, r, "abc")
+	}))
+	defer server.Close()
+
+	// One item is held by the worker and one fills the queue.
+	writer := c.NewBulkWriter(1, 1)
+	writer.Put("users", "a", 1)
+	<-started
+	writer.Put("users", "b", 2)
+
+	added := make(chan error)
+	go func() { added <- writer.Put("users", "c", 3) }()
+
+	select {
+	case <-added:
+		t.Fatal("Add() did not block while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if failures := writer.Close(); len(failures) != 0 {
+		t.Errorf("Close() = %+v", failures)
+	}
+	if stats := writer.Stats(); stats.Succeeded != 3 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestBulkWriterClosed(t *testing.T) {
+	writer := NewClient("test").NewBulkWriter(2, 0)
+	if failures := writer.Close(); len(failures) != 0 {
+		t.Errorf("Close() = %+v", failures)
+	}
+
+	// Closing twice is harmless.
+	writer.Close()
+
+	if err := writer.Delete("users", "a"); err != ErrBulkWriterClosed {
+		t.Errorf("Add() after Close() = %v", err)
+	}
+	if stats := writer.Stats(); stats.Queued != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
@@ -30,7 +30,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -53,6 +55,12 @@ var (
 	// impact all new connections made with the default transport.
 	DefaultDialTimeout = 3 * time.Second
 
+	// The number of requests that batch operations like GetMany will run at
+	// the same time if the client does not specify otherwise. This matches
+	// the number of idle connections kept by the DefaultTransport so that
+	// connections get reused rather than constantly re-established.
+	DefaultConcurrency = 4
+
 	// This is the default http.Transport that will be associated with new
 	// clients. If overwritten then only new clients will be impacted, old
 	// clients will continue to use the pre-existing transport.
@@ -111,6 +119,15 @@ type Client struct {
 	// against Orchestrate.
 	HTTPClient *http.Client
 
+	// The number of requests that batch operations like GetMany will issue
+	// concurrently. If this is zero then DefaultConcurrency is used. When
+	// raising this consider raising MaxIdleConnsPerHost on the transport too.
+	Concurrency int
+
+	// The codec used to encode values written to Orchestrate and to decode
+	// the values of results. If this is nil then DefaultCodec is used.
+	Codec Codec
+
 	// The authorization token passed into NewClient().
 	authToken string
 
@@ -160,6 +177,17 @@ func (c *Client) Ping() error {
 	return nil
 }
 
+// Returns the number of requests batch operations should run at once.
+func (c *Client) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	if DefaultConcurrency > 0 {
+		return DefaultConcurrency
+	}
+	return 1
+}
+
 // Executes an HTTP request.
 func (c *Client) doRequest(method, trailing string, headers map[string]string, body io.Reader) (*http.Response, error) {
 	// Get the URL that we should be talking too.
@@ -204,6 +232,17 @@ func (c *Client) doRequest(method, trailing string, headers map[string]string, b
 	return client.Do(req)
 }
 
+// Converts a paging link returned by Orchestrate, such as
+// "/v0/collection?limit=10&offset=10", into the trailing URI part expected
+// by doRequest. Absolute links are accepted as well.
+func trailingFromLink(link string) string {
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		link = u.RequestURI()
+	}
+	link = strings.TrimPrefix(link, "/")
+	return strings.TrimPrefix(link, "v0/")
+}
+
 //
 // OrchestrateError
 //
@@ -242,3 +281,11 @@ func newError(resp *http.Response) error {
 func (e OrchestrateError) Error() string {
 	return fmt.Sprintf("%s (%d): %s", e.Status, e.StatusCode, e.Message)
 }
+
+// Returns true if err is an OrchestrateError with the given status code.
+func hasStatus(err error, code int) bool {
+	if oe, ok := err.(*OrchestrateError); ok {
+		return oe.StatusCode == code
+	}
+	return false
+}
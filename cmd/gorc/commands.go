@@ -0,0 +1,511 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/orchestrate-io/gorc"
+)
+
+// Every command, keyed by name. Groups of commands are nested maps. This is
+// filled in by init since some commands run others.
+var commands map[string]interface{}
+
+func init() {
+	commands = map[string]interface{}{
+		"get":    command(getCmd),
+		"put":    command(putCmd),
+		"patch":  command(patchCmd),
+		"delete": command(deleteCmd),
+		"purge":  command(purgeCmd),
+		"list":   command(listCmd),
+		"search": command(searchCmd),
+		"shell":  command(shellCmd),
+		"events": map[string]interface{}{
+			"get": command(eventsGetCmd),
+			"put": command(eventsPutCmd),
+		},
+		"relations": map[string]interface{}{
+			"get":    command(relationsGetCmd),
+			"put":    command(relationsPutCmd),
+			"delete": command(relationsDeleteCmd),
+		},
+		"refs": map[string]interface{}{
+			"list": command(refsListCmd),
+			"get":  command(refsGetCmd),
+			"diff": command(refsDiffCmd),
+		},
+	}
+}
+
+func getCmd(c *cli, args []string) error {
+	flags := newFlags("get <collection> <key>")
+	ref := flags.String("ref", "", "get the value at this ref")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	result, err := c.client.GetPath(&gorc.Path{
+		Collection: flags.Arg(0),
+		Key:        flags.Arg(1),
+		Ref:        *ref,
+	})
+	if err != nil {
+		return err
+	}
+	return c.out.one(pathRow(&result.Path, result.RawValue))
+}
+
+func putCmd(c *cli, args []string) error {
+	flags := newFlags("put <collection> <key>")
+	file := flags.String("f", "-", "file holding the value, - for stdin")
+	ifMatch := flags.String("if-match", "", "only write if this is the current ref")
+	ifAbsent := flags.Bool("if-absent", false, "only write if the key holds no value")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	value, err := c.input(*file)
+	if err != nil {
+		return err
+	}
+	defer value.Close()
+
+	collection, key := flags.Arg(0), flags.Arg(1)
+	var path *gorc.Path
+	switch {
+	case *ifMatch != "":
+		path, err = c.client.PutIfUnmodifiedRaw(&gorc.Path{Collection: collection, Key: key, Ref: *ifMatch}, value)
+	case *ifAbsent:
+		path, err = c.client.PutIfAbsentRaw(collection, key, value)
+	default:
+		path, err = c.client.PutRaw(collection, key, value)
+	}
+	if err != nil {
+		return err
+	}
+	return c.out.one(pathRow(path, nil))
+}
+
+func patchCmd(c *cli, args []string) error {
+	flags := newFlags("patch <collection> <key>")
+	file := flags.String("f", "-", "file holding the JSON patch, - for stdin")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	value, err := c.input(*file)
+	if err != nil {
+		return err
+	}
+	defer value.Close()
+
+	path, err := c.client.PatchRaw(flags.Arg(0), flags.Arg(1), value)
+	if err != nil {
+		return err
+	}
+	return c.out.one(pathRow(path, nil))
+}
+
+func deleteCmd(c *cli, args []string) error {
+	flags := newFlags("delete <collection> <key>")
+	ifMatch := flags.String("if-match", "", "only delete if this is the current ref")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	collection, key := flags.Arg(0), flags.Arg(1)
+	var err error
+	if *ifMatch != "" {
+		err = c.client.DeleteIfUnmodified(&gorc.Path{Collection: collection, Key: key, Ref: *ifMatch})
+	} else {
+		err = c.client.Delete(collection, key)
+	}
+	if err != nil {
+		return err
+	}
+	return c.out.message("deleted %s/%s", collection, key)
+}
+
+func purgeCmd(c *cli, args []string) error {
+	flags := newFlags("purge <collection> <key>")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	if err := c.client.Purge(flags.Arg(0), flags.Arg(1)); err != nil {
+		return err
+	}
+	return c.out.message("purged %s/%s", flags.Arg(0), flags.Arg(1))
+}
+
+func listCmd(c *cli, args []string) error {
+	flags := newFlags("list <collection>")
+	limit := flags.Int("limit", 10, "number of items per page")
+	start := flags.String("start", "", "list keys starting with this key")
+	after := flags.String("after", "", "list keys after this key")
+	end := flags.String("end", "", "stop at this key")
+	all := flags.Bool("all", false, "fetch every page")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *after != "" && (*start != "" || *end != "") {
+		return fmt.Errorf("-after can not be combined with -start or -end")
+	}
+
+	collection := flags.Arg(0)
+	var results *gorc.KVResults
+	var err error
+	switch {
+	case *end != "":
+		results, err = c.client.ListRange(collection, *start, *end, *limit)
+	case *start != "":
+		results, err = c.client.ListStart(collection, *start, *limit)
+	case *after != "":
+		results, err = c.client.ListAfter(collection, *after, *limit)
+	default:
+		results, err = c.client.List(collection, *limit)
+	}
+
+	var rows []row
+	for {
+		if err != nil {
+			return err
+		}
+		rows = append(rows, kvRows(results)...)
+		if !*all || !results.HasNext() {
+			break
+		}
+		results, err = c.client.ListGetNext(results)
+	}
+	return c.out.many(rows)
+}
+
+func searchCmd(c *cli, args []string) error {
+	flags := newFlags("search <collection> <query>")
+	sortBy := flags.String("sort", "", "sort by a field, such as value.name:asc")
+	limit := flags.Int("limit", 10, "number of results per page")
+	offset := flags.Int("offset", 0, "number of results to skip")
+	all := flags.Bool("all", false, "fetch every page")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	collection, query := flags.Arg(0), flags.Arg(1)
+	var results *gorc.SearchResults
+	var err error
+	if *sortBy != "" {
+		results, err = c.client.SearchSorted(collection, query, *sortBy, *limit, *offset)
+	} else {
+		results, err = c.client.Search(collection, query, *limit, *offset)
+	}
+
+	var rows []row
+	for {
+		if err != nil {
+			return err
+		}
+		rows = append(rows, searchRows(results)...)
+		if !*all || !results.HasNext() {
+			break
+		}
+		results, err = c.client.SearchGetNext(results)
+	}
+	return c.out.many(rows)
+}
+
+func eventsGetCmd(c *cli, args []string) error {
+	flags := newFlags("events get <collection> <key> <kind>")
+	start := flags.String("start", "", "only events at or after this time")
+	end := flags.String("end", "", "only events at or before this time")
+	limit := flags.Int("limit", 10, "maximum number of events, 0 for all")
+	if err := parse(flags, args, 3); err != nil {
+		return err
+	}
+
+	query := &gorc.EventRange{}
+	if *start != "" {
+		t, err := parseTime(*start)
+		if err != nil {
+			return err
+		}
+		query.Start = gorc.EventBoundAt(t)
+	}
+	if *end != "" {
+		t, err := parseTime(*end)
+		if err != nil {
+			return err
+		}
+		query.End = gorc.EventBoundAt(t)
+	}
+	if *limit > 0 && *limit < 100 {
+		query.PageSize = *limit
+	}
+
+	var rows []row
+	iter := c.client.IterateEvents(flags.Arg(0), flags.Arg(1), flags.Arg(2), query)
+	for (*limit <= 0 || len(rows) < *limit) && iter.Next() {
+		event := iter.Event()
+		path := event.Path
+		path.Collection, path.Key, path.Kind = flags.Arg(0), flags.Arg(1), flags.Arg(2)
+		path.Timestamp, path.Ordinal = event.Timestamp, event.Ordinal
+		rows = append(rows, eventRow(&path, event.RawValue))
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return c.out.many(rows)
+}
+
+func eventsPutCmd(c *cli, args []string) error {
+	flags := newFlags("events put <collection> <key> <kind>")
+	file := flags.String("f", "-", "file holding the value, - for stdin")
+	at := flags.String("time", "", "time of the event, defaults to now")
+	if err := parse(flags, args, 3); err != nil {
+		return err
+	}
+
+	value, err := c.input(*file)
+	if err != nil {
+		return err
+	}
+	defer value.Close()
+
+	collection, key, kind := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	var path *gorc.EventPath
+	if *at != "" {
+		var t time.Time
+		if t, err = parseTime(*at); err != nil {
+			return err
+		}
+		path, err = c.client.PutEventAtRaw(collection, key, kind, t, value)
+	} else {
+		path, err = c.client.PostEventRaw(collection, key, kind, value)
+	}
+	if err != nil {
+		return err
+	}
+	return c.out.one(eventRow(path, nil))
+}
+
+func relationsGetCmd(c *cli, args []string) error {
+	flags := newFlags("relations get <collection> <key> <kind> [kind...]")
+	limit := flags.Int("limit", 0, "maximum number of results, 0 for all")
+	if err := parse(flags, args, -3); err != nil {
+		return err
+	}
+
+	var rows []row
+	iter := c.client.IterateRelations(flags.Arg(0), flags.Arg(1), flags.Args()[2:], 100)
+	for (*limit <= 0 || len(rows) < *limit) && iter.Next() {
+		result := iter.Result()
+		rows = append(rows, pathRow(&result.Path, result.RawValue))
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return c.out.many(rows)
+}
+
+func relationsPutCmd(c *cli, args []string) error {
+	flags := newFlags("relations put <collection> <key> <kind> <to-collection> <to-key>")
+	if err := parse(flags, args, 5); err != nil {
+		return err
+	}
+
+	a := flags.Args()
+	if err := c.client.PutRelation(a[0], a[1], a[2], a[3], a[4]); err != nil {
+		return err
+	}
+	return c.out.message("related %s/%s -[%s]-> %s/%s", a[0], a[1], a[2], a[3], a[4])
+}
+
+func relationsDeleteCmd(c *cli, args []string) error {
+	flags := newFlags("relations delete <collection> <key> <kind> <to-collection> <to-key>")
+	if err := parse(flags, args, 5); err != nil {
+		return err
+	}
+
+	a := flags.Args()
+	if err := c.client.DeleteRelation(a[0], a[1], a[2], a[3], a[4]); err != nil {
+		return err
+	}
+	return c.out.message("unrelated %s/%s -[%s]-> %s/%s", a[0], a[1], a[2], a[3], a[4])
+}
+
+func refsListCmd(c *cli, args []string) error {
+	flags := newFlags("refs list <collection> <key>")
+	values := flags.Bool("values", false, "include the value of each ref")
+	limit := flags.Int("limit", 10, "maximum number of refs, 0 for all")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	var rows []row
+	iter := c.client.IterateRefs(flags.Arg(0), flags.Arg(1), *values, false)
+	for (*limit <= 0 || len(rows) < *limit) && iter.Next() {
+		ref := iter.Result()
+		r := pathRow(&ref.Path, ref.RawValue)
+		r.fields = append(r.fields,
+			field{"reftime", ref.Time().UTC().Format(time.RFC3339Nano)},
+			field{"deleted", strconv.FormatBool(ref.IsDeleted())})
+		rows = append(rows, r)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return c.out.many(rows)
+}
+
+func refsGetCmd(c *cli, args []string) error {
+	flags := newFlags("refs get <collection> <key> <ref>")
+	if err := parse(flags, args, 3); err != nil {
+		return err
+	}
+
+	result, err := c.client.GetRef(flags.Arg(0), flags.Arg(1), flags.Arg(2))
+	if err != nil {
+		return err
+	}
+	return c.out.one(pathRow(&result.Path, result.RawValue))
+}
+
+func refsDiffCmd(c *cli, args []string) error {
+	flags := newFlags("refs diff <collection> <key> <from-ref> <to-ref>")
+	patch := flags.Bool("patch", false, "print the changes as a JSON patch")
+	if err := parse(flags, args, 4); err != nil {
+		return err
+	}
+
+	diff, err := c.client.DiffRefs(flags.Arg(0), flags.Arg(1), flags.Arg(2), flags.Arg(3))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *patch:
+		return c.out.pretty(diff.PatchSet())
+	case c.out.format == "table":
+		_, err := io.WriteString(c.out.w, diff.String())
+		return err
+	}
+
+	rows := make([]row, len(diff.Changes))
+	for i, change := range diff.Changes {
+		value, err := json.Marshal(map[string]interface{}{"from": change.From, "to": change.To})
+		if err != nil {
+			return err
+		}
+		rows[i] = row{fields: []field{{"op", change.Op}, {"path", change.Path}}, value: value}
+	}
+	return c.out.many(rows)
+}
+
+// Returns a flag set for a command that reports errors rather than exiting.
+func newFlags(usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(usage, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gorc %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parses the flags of a command and checks it was given n arguments. A
+// negative n means at least -n arguments.
+func parse(flags *flag.FlagSet, args []string, n int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (n >= 0 && flags.NArg() != n) || (n < 0 && flags.NArg() < -n) {
+		return fmt.Errorf("usage: gorc %s", flags.Name())
+	}
+	return nil
+}
+
+// Opens the file a value should be read from, where "-" is stdin.
+func (c *cli) input(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return ioutil.NopCloser(c.stdin), nil
+	}
+	return os.Open(file)
+}
+
+// Parses a time given as milliseconds since the epoch or in RFC 3339 format.
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return (&gorc.EventBound{Timestamp: ms}).Time(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected milliseconds or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// Returns the row for a key/value path.
+func pathRow(path *gorc.Path, value json.RawMessage) row {
+	return row{
+		fields: []field{
+			{"collection", path.Collection},
+			{"key", path.Key},
+			{"ref", path.Ref},
+		},
+		value: value,
+	}
+}
+
+// Returns the row for an event.
+func eventRow(path *gorc.EventPath, value json.RawMessage) row {
+	return row{
+		fields: []field{
+			{"collection", path.Collection},
+			{"key", path.Key},
+			{"kind", path.Kind},
+			{"timestamp", strconv.FormatUint(path.Timestamp, 10)},
+			{"ordinal", strconv.FormatUint(path.Ordinal, 10)},
+			{"ref", path.Ref},
+		},
+		value: value,
+	}
+}
+
+// Returns the rows for a page of list results.
+func kvRows(results *gorc.KVResults) []row {
+	rows := make([]row, len(results.Results))
+	for i := range results.Results {
+		rows[i] = pathRow(&results.Results[i].Path, results.Results[i].RawValue)
+	}
+	return rows
+}
+
+// Returns the rows for a page of search results.
+func searchRows(results *gorc.SearchResults) []row {
+	rows := make([]row, len(results.Results))
+	for i := range results.Results {
+		result := &results.Results[i]
+		rows[i] = pathRow(&result.Path, result.RawValue)
+		rows[i].fields = append(rows[i].fields, field{"score", strconv.FormatFloat(result.Score, 'g', -1, 64)})
+	}
+	return rows
+}
@@ -0,0 +1,93 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The settings for talking to one Orchestrate account.
+type profile struct {
+	APIKey string `json:"api_key"`
+	Host   string `json:"host,omitempty"`
+}
+
+// Loads the named profile, or "default" if name is empty. The environment
+// variables ORCHESTRATE_API_KEY and ORCHESTRATE_API_HOST override whatever
+// the default profile holds, but never a profile asked for by name, so that
+// a key left in the environment can not redirect writes to another account.
+// A missing config file is only an error if a profile was asked for by name.
+func loadProfile(name string) (*profile, error) {
+	explicit := name != ""
+	if !explicit {
+		name = "default"
+	}
+
+	p := &profile{}
+	profiles, err := readConfig(configPath())
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return nil, err
+	}
+	if found := profiles[name]; found != nil {
+		p = found
+	} else if explicit {
+		return nil, fmt.Errorf("no profile named %q in %s", name, configPath())
+	}
+
+	if explicit {
+		if p.APIKey == "" {
+			return nil, fmt.Errorf("profile %q in %s has no api_key", name, configPath())
+		}
+		return p, nil
+	}
+
+	if key := os.Getenv("ORCHESTRATE_API_KEY"); key != "" {
+		p.APIKey = key
+	}
+	if host := os.Getenv("ORCHESTRATE_API_HOST"); host != "" {
+		p.Host = host
+	}
+
+	if p.APIKey == "" {
+		return nil, fmt.Errorf("no API key, set ORCHESTRATE_API_KEY or add a profile to %s", configPath())
+	}
+	return p, nil
+}
+
+// Returns the location of the config file.
+func configPath() string {
+	if path := os.Getenv("GORC_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".gorc")
+}
+
+// Reads the profiles held in a config file.
+func readConfig(path string) (map[string]*profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]*profile)
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sets environment variables for the length of a test.
+func setenv(vars map[string]string) func() {
+	old := make(map[string]string)
+	for name, value := range vars {
+		old[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range old {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config")
+	ioutil.WriteFile(config, []byte(`{
+		"default": {"api_key": "default-key"},
+		"prod": {"api_key": "prod-key", "host": "prod.example.com"},
+		"empty": null
+	}`), 0600)
+	defer setenv(map[string]string{
+		"GORC_CONFIG":          config,
+		"ORCHESTRATE_API_KEY":  "env-key",
+		"ORCHESTRATE_API_HOST": "env.example.com",
+	})()
+
+	// The environment overrides the default profile.
+	if p, err := loadProfile(""); err != nil || p.APIKey != "env-key" || p.Host != "env.example.com" {
+		t.Errorf("loadProfile(\"\") = %+v, %v", p, err)
+	}
+
+	// A profile named on the command line wins over the environment.
+	if p, err := loadProfile("prod"); err != nil || p.APIKey != "prod-key" || p.Host != "prod.example.com" {
+		t.Errorf("loadProfile(\"prod\") = %+v, %v", p, err)
+	}
+
+	if p, err := loadProfile("empty"); err == nil {
+		t.Errorf("loadProfile(\"empty\") = %+v, expected an error", p)
+	}
+	if p, err := loadProfile("missing"); err == nil {
+		t.Errorf("loadProfile(\"missing\") = %+v, expected an error", p)
+	}
+
+	// A null default profile falls back on the environment.
+	ioutil.WriteFile(config, []byte(`{"default": null}`), 0600)
+	if p, err := loadProfile(""); err != nil || p.APIKey != "env-key" {
+		t.Errorf("loadProfile(\"\") with a null default = %+v, %v", p, err)
+	}
+}
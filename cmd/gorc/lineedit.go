@@ -0,0 +1,186 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Returned by readLine when the user presses Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// Reads lines from the terminal with history and tab completion. When stdin
+// is not a terminal lines are read as is with no editing.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+
+	// Returns the possible completions of the last word of a line.
+	complete func(line string) []string
+
+	history []string
+}
+
+// Returns a line editor reading from stdin.
+func newLineEditor(out io.Writer, complete func(line string) []string) *lineEditor {
+	return &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      out,
+		terminal: stty("-g") == nil,
+		complete: complete,
+	}
+}
+
+// Reads a line after printing the prompt. This returns io.EOF when input
+// ends or the user presses Ctrl-D on an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+	if !e.terminal {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	// Take the terminal out of line mode for just this line, so that
+	// commands like an editor see a normal terminal.
+	if err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return "", err
+	}
+	defer stty("icanon", "echo", "isig")
+
+	line := []rune{}
+	position := len(e.history)
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r\033[K%s%s", prompt, string(line))
+	}
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			s := string(line)
+			if strings.TrimSpace(s) != "" {
+				e.history = append(e.history, s)
+			}
+			return s, nil
+
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+
+		case 127, 8: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+
+		case 21: // Ctrl-U
+			line = line[:0]
+			redraw()
+
+		case '\t':
+			line = e.tab(line)
+			redraw()
+
+		case 27: // Escape sequences, only up and down are handled.
+			if b, _ := e.in.ReadByte(); b != '[' {
+				continue
+			}
+			b, _ := e.in.ReadByte()
+			switch {
+			case b == 'A' && position > 0:
+				position--
+				line = []rune(e.history[position])
+			case b == 'B' && position < len(e.history):
+				position++
+				line = line[:0]
+				if position < len(e.history) {
+					line = []rune(e.history[position])
+				}
+			}
+			redraw()
+
+		default:
+			if r >= ' ' {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// Completes the last word of the line. A single match is completed in full,
+// otherwise the line is extended by the prefix the matches share and the
+// matches are listed.
+func (e *lineEditor) tab(line []rune) []rune {
+	s := string(line)
+	candidates := e.complete(s)
+	if len(candidates) == 0 {
+		return line
+	}
+
+	word := s[strings.LastIndex(s, " ")+1:]
+	if len(candidates) == 1 {
+		return []rune(s[:len(s)-len(word)] + candidates[0] + " ")
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		return []rune(s[:len(s)-len(word)] + prefix)
+	}
+
+	sort.Strings(candidates)
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	return line
+}
+
+// Returns the longest prefix shared by every string.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Runs stty against the terminal on stdin.
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
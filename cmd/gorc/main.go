@@ -0,0 +1,148 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// A command line tool for working with Orchestrate.io.
+//
+// The API key is taken from the ORCHESTRATE_API_KEY environment variable or
+// from a profile in the config file (~/.gorc by default, or GORC_CONFIG). A
+// profile chosen with -profile always wins over the environment:
+//
+//	{
+//	  "default": {"api_key": "...", "host": "api.orchestrate.io"},
+//	  "staging": {"api_key": "..."}
+//	}
+//
+// Run "gorc help" for the list of commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/orchestrate-io/gorc"
+)
+
+const usage = `usage: gorc [-profile name] [-o json|jsonl|table] <command> [flags] [args]
+
+Key/value:
+  get [-ref ref] <collection> <key>
+  put [-f file] [-if-match ref] [-if-absent] <collection> <key>
+  patch [-f file] <collection> <key>
+  delete [-if-match ref] <collection> <key>
+  purge <collection> <key>
+  list [-limit n] [-start key | -after key] [-end key] [-all] <collection>
+  search [-sort field:asc] [-limit n] [-offset n] [-all] <collection> <query>
+
+Events:
+  events get [-start time] [-end time] [-limit n] <collection> <key> <kind>
+  events put [-f file] [-time time] <collection> <key> <kind>
+
+Relations:
+  relations get [-limit n] <collection> <key> <kind> [kind...]
+  relations put <collection> <key> <kind> <to-collection> <to-key>
+  relations delete <collection> <key> <kind> <to-collection> <to-key>
+
+Refs:
+  refs list [-values] [-limit n] <collection> <key>
+  refs get <collection> <key> <ref>
+  refs diff [-patch] <collection> <key> <from-ref> <to-ref>
+
+Interactive:
+  shell [collection]
+
+Values are read from stdin unless -f is given. Times are milliseconds since
+the epoch or RFC 3339.
+`
+
+// The state shared by every command.
+type cli struct {
+	client *gorc.Client
+	out    *output
+	stdin  io.Reader
+}
+
+// A command implementation, given the arguments that follow its name.
+type command func(c *cli, args []string) error
+
+func main() {
+	flags := flag.NewFlagSet("gorc", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	profile := flags.String("profile", os.Getenv("GORC_PROFILE"), "config profile to use")
+	format := flags.String("o", "json", "output format: json, jsonl or table")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	args := flags.Args()
+	if len(args) == 0 || args[0] == "help" {
+		fmt.Fprint(os.Stdout, usage)
+		return
+	}
+
+	out, err := newOutput(os.Stdout, *format)
+	if err != nil {
+		fatal(err)
+	}
+
+	cfg, err := loadProfile(*profile)
+	if err != nil {
+		fatal(err)
+	}
+
+	client := gorc.NewClient(cfg.APIKey)
+	if cfg.Host != "" {
+		client.APIHost = cfg.Host
+	}
+
+	c := &cli{client: client, out: out, stdin: os.Stdin}
+	if err := c.run(args); err != nil {
+		fatal(err)
+	}
+}
+
+// Runs the command named by the first argument.
+func (c *cli) run(args []string) error {
+	cmd, rest, err := lookup(commands, args)
+	if err != nil {
+		return err
+	}
+	return cmd(c, rest)
+}
+
+// Finds the command named by the leading arguments, descending into groups
+// such as "events get".
+func lookup(table map[string]interface{}, args []string) (command, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("missing command, run \"gorc help\" for usage")
+	}
+
+	switch entry := table[args[0]].(type) {
+	case command:
+		return entry, args[1:], nil
+	case map[string]interface{}:
+		if len(args) == 1 {
+			return nil, nil, fmt.Errorf("%s needs a subcommand, run \"gorc help\" for usage", args[0])
+		}
+		return lookup(entry, args[1:])
+	}
+	return nil, nil, fmt.Errorf("unknown command %q, run \"gorc help\" for usage", args[0])
+}
+
+// Prints an error and exits.
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "gorc:", err)
+	os.Exit(1)
+}
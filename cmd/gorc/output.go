@@ -0,0 +1,156 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// The widest a value is allowed to be in table output.
+const maxTableValue = 80
+
+// A named column of a result.
+type field struct {
+	name  string
+	value string
+}
+
+// A single result to be printed: a set of columns such as the collection,
+// key and ref, followed by the JSON value.
+type row struct {
+	fields []field
+	value  json.RawMessage
+}
+
+// Prints results in the chosen format.
+type output struct {
+	w      io.Writer
+	format string
+}
+
+// Returns an output that writes to w in the given format.
+func newOutput(w io.Writer, format string) (*output, error) {
+	switch format {
+	case "json", "jsonl", "table":
+		return &output{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected json, jsonl or table", format)
+}
+
+// Prints a single result.
+func (o *output) one(r row) error {
+	if o.format == "json" {
+		return o.pretty(r.object())
+	}
+	return o.many([]row{r})
+}
+
+// Prints a list of results.
+func (o *output) many(rows []row) error {
+	switch o.format {
+	case "jsonl":
+		encoder := json.NewEncoder(o.w)
+		for _, r := range rows {
+			if err := encoder.Encode(r.object()); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "table":
+		return o.table(rows)
+	}
+
+	objects := make([]map[string]interface{}, len(rows))
+	for i, r := range rows {
+		objects[i] = r.object()
+	}
+	return o.pretty(objects)
+}
+
+// Prints a short status message, such as the ref of a write.
+func (o *output) message(format string, args ...interface{}) error {
+	_, err := fmt.Fprintf(o.w, format+"\n", args...)
+	return err
+}
+
+// Prints any value as indented JSON.
+func (o *output) pretty(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(o.w, "%s\n", data)
+	return err
+}
+
+// Prints results as aligned columns, with the value compacted onto one line.
+func (o *output) table(rows []row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	var header []string
+	for _, f := range rows[0].fields {
+		header = append(header, strings.ToUpper(f.name))
+	}
+	header = append(header, "VALUE")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, r := range rows {
+		var columns []string
+		for _, f := range r.fields {
+			columns = append(columns, f.value)
+		}
+		columns = append(columns, tableValue(r.value))
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Returns the result as a JSON object holding its columns and value.
+func (r *row) object() map[string]interface{} {
+	object := make(map[string]interface{}, len(r.fields)+1)
+	for _, f := range r.fields {
+		object[f.name] = f.value
+	}
+	if len(r.value) > 0 {
+		object["value"] = r.value
+	}
+	return object
+}
+
+// Compacts a value onto a single line, truncating it if it is too long.
+func tableValue(value json.RawMessage) string {
+	buf := bytes.NewBuffer(nil)
+	if err := json.Compact(buf, value); err != nil {
+		buf.Reset()
+		buf.Write(value)
+	}
+
+	s := buf.String()
+	if utf8.RuneCountInString(s) > maxTableValue {
+		runes := []rune(s)
+		s = string(runes[:maxTableValue-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOutputFormats(t *testing.T) {
+	rows := []row{
+		{fields: []field{{"collection", "users"}, {"key", "a"}}, value: []byte(`{"name": "Al"}`)},
+		{fields: []field{{"collection", "users"}, {"key", "bb"}}, value: []byte(`{"name": "Bo"}`)},
+	}
+
+	tests := map[string]string{
+		"jsonl": `{"collection":"users","key":"a","value":{"name":"Al"}}` + "\n" +
+			`{"collection":"users","key":"bb","value":{"name":"Bo"}}` + "\n",
+		"table": "COLLECTION  KEY  VALUE\n" +
+			"users       a    {\"name\":\"Al\"}\n" +
+			"users       bb   {\"name\":\"Bo\"}\n",
+	}
+	for format, expected := range tests {
+		buf := bytes.NewBuffer(nil)
+		out, err := newOutput(buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := out.many(rows); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s output:\n%s\nexpected:\n%s", format, buf.String(), expected)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	expected := time.Unix(1398286518, 286000000)
+	for _, s := range []string{"1398286518286", "2014-04-23T20:55:18.286Z"} {
+		parsed, err := parseTime(s)
+		if err != nil {
+			t.Errorf("parseTime(%q): %s", s, err)
+		} else if !parsed.Equal(expected) {
+			t.Errorf("parseTime(%q) = %s, expected %s", s, parsed, expected)
+		}
+	}
+	if _, err := parseTime("yesterday"); err == nil {
+		t.Error("parseTime accepted an invalid time")
+	}
+}
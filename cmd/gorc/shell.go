@@ -0,0 +1,522 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/orchestrate-io/gorc"
+)
+
+const shellUsage = `Commands work on the current collection:
+  use <collection>        switch to a collection
+  get <key>               show a value
+  put <key> <json>        store a value
+  edit <key>              edit a value in $EDITOR, saved only if unchanged meanwhile
+  delete <key>            delete a value
+  list [limit]            list values in key order
+  search <query>          search the collection
+  next, prev              page through the last list or search
+  help                    show this message
+  exit                    leave the shell
+
+Any other gorc command can be run as is, for example: refs list users mary
+`
+
+// The number of recent keys remembered for completion.
+const maxRecentKeys = 100
+
+// The state of an interactive session.
+type shell struct {
+	cli        *cli
+	collection string
+
+	// Names seen during the session, offered as completions.
+	collections []string
+	recentKeys  []string
+
+	// The pages of the last list, oldest first, or the last search.
+	listPages []*gorc.KVResults
+	search    *gorc.SearchResults
+}
+
+// Every shell command, keyed by name.
+var shellCommands map[string]func(s *shell, args []string) error
+
+func init() {
+	shellCommands = map[string]func(s *shell, args []string) error{
+		"use":    (*shell).use,
+		"get":    (*shell).get,
+		"put":    (*shell).put,
+		"edit":   (*shell).edit,
+		"delete": (*shell).delete,
+		"list":   (*shell).list,
+		"search": (*shell).searchCollection,
+		"next":   (*shell).next,
+		"prev":   (*shell).prev,
+	}
+}
+
+func shellCmd(c *cli, args []string) error {
+	flags := newFlags("shell [collection]")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: gorc %s", flags.Name())
+	}
+
+	s := &shell{cli: c}
+	if flags.NArg() > 0 {
+		s.setCollection(flags.Arg(0))
+	}
+
+	editor := newLineEditor(c.out.w, s.complete)
+	for {
+		line, err := editor.readLine(s.prompt())
+		if err == errInterrupted {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args, err := parseLine(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Fprint(c.out.w, shellUsage)
+			continue
+		}
+
+		if err := s.exec(args); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+	}
+}
+
+// Runs a single command line.
+func (s *shell) exec(args []string) error {
+	if cmd, ok := shellCommands[args[0]]; ok {
+		if args[0] != "use" && s.collection == "" {
+			return fmt.Errorf("no collection selected, run \"use <collection>\" first")
+		}
+		return cmd(s, args[1:])
+	}
+	return s.cli.run(args)
+}
+
+// Returns the prompt, showing the current collection.
+func (s *shell) prompt() string {
+	if s.collection == "" {
+		return "gorc> "
+	}
+	return "gorc:" + s.collection + "> "
+}
+
+func (s *shell) use(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: use <collection>")
+	}
+	s.setCollection(args[0])
+	return nil
+}
+
+func (s *shell) get(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: get <key>")
+	}
+
+	result, err := s.cli.client.Get(s.collection, args[0])
+	if err != nil {
+		return err
+	}
+	s.remember(args[0])
+	return s.cli.out.one(pathRow(&result.Path, result.RawValue))
+}
+
+func (s *shell) put(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: put <key> <json>")
+	}
+
+	value := []byte(strings.Join(args[1:], " "))
+	if !json.Valid(value) {
+		return fmt.Errorf("value is not valid JSON")
+	}
+
+	path, err := s.cli.client.PutRaw(s.collection, args[0], bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	s.remember(args[0])
+	return s.cli.out.one(pathRow(path, nil))
+}
+
+// Opens the value in an editor and stores the result if it changed. The
+// write is conditional on the ref that was edited so that changes made by
+// someone else in the meantime are not overwritten.
+func (s *shell) edit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: edit <key>")
+	}
+
+	result, err := s.cli.client.Get(s.collection, args[0])
+	if err != nil {
+		return err
+	}
+	s.remember(args[0])
+
+	original := bytes.NewBuffer(nil)
+	if err := json.Indent(original, result.RawValue, "", "  "); err != nil {
+		return err
+	}
+	original.WriteString("\n")
+
+	edited, err := editText(original.Bytes())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bytes.TrimSpace(edited), bytes.TrimSpace(original.Bytes())) {
+		return s.cli.out.message("no changes")
+	}
+	if !json.Valid(edited) {
+		return fmt.Errorf("edited value is not valid JSON, nothing was saved")
+	}
+
+	path, err := s.cli.client.PutIfUnmodifiedRaw(&result.Path, bytes.NewReader(edited))
+	if oe, ok := err.(*gorc.OrchestrateError); ok && oe.StatusCode == 412 {
+		return fmt.Errorf("%s/%s was changed while you were editing, nothing was saved", s.collection, args[0])
+	} else if err != nil {
+		return err
+	}
+	return s.cli.out.one(pathRow(path, nil))
+}
+
+func (s *shell) delete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete <key>")
+	}
+
+	if err := s.cli.client.Delete(s.collection, args[0]); err != nil {
+		return err
+	}
+	return s.cli.out.message("deleted %s/%s", s.collection, args[0])
+}
+
+func (s *shell) list(args []string) error {
+	limit := 10
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("usage: list [limit]")
+		}
+		limit = n
+	}
+
+	results, err := s.cli.client.List(s.collection, limit)
+	if err != nil {
+		return err
+	}
+	s.search = nil
+	s.listPages = []*gorc.KVResults{results}
+	return s.showList()
+}
+
+func (s *shell) searchCollection(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: search <query>")
+	}
+
+	results, err := s.cli.client.Search(s.collection, strings.Join(args, " "), 10, 0)
+	if err != nil {
+		return err
+	}
+	s.listPages = nil
+	s.search = results
+	return s.showSearch()
+}
+
+func (s *shell) next(args []string) error {
+	switch {
+	case s.search != nil:
+		if !s.search.HasNext() {
+			return fmt.Errorf("this is the last page")
+		}
+		results, err := s.cli.client.SearchGetNext(s.search)
+		if err != nil {
+			return err
+		}
+		s.search = results
+		return s.showSearch()
+
+	case len(s.listPages) > 0:
+		current := s.listPages[len(s.listPages)-1]
+		if !current.HasNext() {
+			return fmt.Errorf("this is the last page")
+		}
+		results, err := s.cli.client.ListGetNext(current)
+		if err != nil {
+			return err
+		}
+		s.listPages = append(s.listPages, results)
+		return s.showList()
+	}
+	return fmt.Errorf("nothing to page through, run list or search first")
+}
+
+func (s *shell) prev(args []string) error {
+	switch {
+	case s.search != nil:
+		if !s.search.HasPrev() {
+			return fmt.Errorf("this is the first page")
+		}
+		results, err := s.cli.client.SearchGetPrev(s.search)
+		if err != nil {
+			return err
+		}
+		s.search = results
+		return s.showSearch()
+
+	case len(s.listPages) > 0:
+		// Lists only link forwards so earlier pages are kept as we go.
+		if len(s.listPages) == 1 {
+			return fmt.Errorf("this is the first page")
+		}
+		s.listPages = s.listPages[:len(s.listPages)-1]
+		return s.showList()
+	}
+	return fmt.Errorf("nothing to page through, run list or search first")
+}
+
+// Prints the current page of list results.
+func (s *shell) showList() error {
+	results := s.listPages[len(s.listPages)-1]
+	for _, result := range results.Results {
+		s.remember(result.Path.Key)
+	}
+	if err := s.cli.out.many(kvRows(results)); err != nil {
+		return err
+	}
+	return s.cli.out.message("page %d%s", len(s.listPages), more(results.HasNext()))
+}
+
+// Prints the current page of search results.
+func (s *shell) showSearch() error {
+	for _, result := range s.search.Results {
+		s.remember(result.Path.Key)
+	}
+	if err := s.cli.out.many(searchRows(s.search)); err != nil {
+		return err
+	}
+	return s.cli.out.message("%d of %d results%s", len(s.search.Results), s.search.TotalCount, more(s.search.HasNext()))
+}
+
+// Returns a hint that there are further pages.
+func more(hasNext bool) string {
+	if hasNext {
+		return ", \"next\" for more"
+	}
+	return ""
+}
+
+// Switches the current collection.
+func (s *shell) setCollection(collection string) {
+	s.collection = collection
+	s.listPages, s.search = nil, nil
+	for _, c := range s.collections {
+		if c == collection {
+			return
+		}
+	}
+	s.collections = append(s.collections, collection)
+}
+
+// Remembers a key for completion, most recent first.
+func (s *shell) remember(key string) {
+	for i, k := range s.recentKeys {
+		if k == key {
+			s.recentKeys = append(s.recentKeys[:i], s.recentKeys[i+1:]...)
+			break
+		}
+	}
+	s.recentKeys = append([]string{key}, s.recentKeys...)
+	if len(s.recentKeys) > maxRecentKeys {
+		s.recentKeys = s.recentKeys[:maxRecentKeys]
+	}
+}
+
+// Returns the completions for the last word of a line: command names for
+// the first word, collections after "use" and recent keys after commands
+// that take a key.
+func (s *shell) complete(line string) []string {
+	words := strings.Split(line, " ")
+	word := words[len(words)-1]
+
+	var options []string
+	switch {
+	case len(words) == 1:
+		options = []string{"exit", "help"}
+		for name := range shellCommands {
+			options = append(options, name)
+		}
+		for name := range commands {
+			options = append(options, name)
+		}
+	case len(words) == 2 && words[0] == "use":
+		options = s.collections
+	case len(words) == 2 && (words[0] == "get" || words[0] == "put" || words[0] == "edit" || words[0] == "delete"):
+		options = s.recentKeys
+	}
+
+	var matches []string
+	seen := make(map[string]bool)
+	for _, option := range options {
+		if strings.HasPrefix(option, word) && !seen[option] {
+			seen[option] = true
+			matches = append(matches, option)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// Shell commands whose last argument is the rest of the line exactly as
+// typed, keyed by the number of words before it including the command.
+var rawShellArgs = map[string]int{
+	"put": 2,
+}
+
+// Splits a command line into its command and arguments. Most commands are
+// split with splitArgs, but those in rawShellArgs take the end of the line
+// as is so that any JSON can be typed without quoting it.
+func parseLine(line string) ([]string, error) {
+	args, _, err := splitLeading(line, 1)
+	if err != nil || len(args) == 0 {
+		return splitArgs(line)
+	}
+
+	n, ok := rawShellArgs[args[0]]
+	if !ok {
+		return splitArgs(line)
+	}
+
+	args, rest, err := splitLeading(line, n)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		args = append(args, rest)
+	}
+	return args, nil
+}
+
+// Splits a command line into words. Words may be quoted with single or
+// double quotes to include spaces, quotes within a word are kept as is.
+func splitArgs(line string) ([]string, error) {
+	args, _, err := splitLeading(line, -1)
+	return args, err
+}
+
+// Splits up to n words from the start of a command line, or every word if n
+// is negative, returning them along with the rest of the line.
+func splitLeading(line string, n int) ([]string, string, error) {
+	var args []string
+	var word []rune
+	inWord := false
+	var quote rune
+
+	for i, r := range line {
+		if n >= 0 && len(args) == n {
+			return args, strings.TrimSpace(line[i:]), nil
+		}
+
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word = append(word, r)
+		case !inWord && (r == '"' || r == '\''):
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, string(word))
+				word, inWord = word[:0], false
+			}
+		default:
+			word, inWord = append(word, r), true
+		}
+	}
+
+	if quote != 0 {
+		return nil, "", fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		args = append(args, string(word))
+	}
+	return args, "", nil
+}
+
+// Opens text in the user's editor and returns the saved result.
+func editText(text []byte) ([]byte, error) {
+	file, err := ioutil.TempFile("", "gorc-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(text); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", file.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor failed: %s", err)
+	}
+
+	return ioutil.ReadFile(file.Name())
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := map[string][]string{
+		`get key`:                  {"get", "key"},
+		`  search  "name:Al Bo"  `: {"search", "name:Al Bo"},
+		`put key '{"a": "b c"}'`:   {"put", "key", `{"a": "b c"}`},
+		`put key {"a":1}`:          {"put", "key", `{"a":1}`},
+		`get ""`:                   {"get", ""},
+	}
+	for line, expected := range tests {
+		args, err := splitArgs(line)
+		if err != nil {
+			t.Errorf("splitArgs(%q): %s", line, err)
+		} else if !reflect.DeepEqual(args, expected) {
+			t.Errorf("splitArgs(%q) = %q, expected %q", line, args, expected)
+		}
+	}
+	if _, err := splitArgs(`get "key`); err == nil {
+		t.Error("splitArgs accepted an unterminated quote")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := map[string][]string{
+		`put mary {"name": "mary"}`:    {"put", "mary", `{"name": "mary"}`},
+		`  put "a b"   {"x": "y z"}  `: {"put", "a b", `{"x": "y z"}`},
+		`put mary "just a string"`:     {"put", "mary", `"just a string"`},
+		`put mary`:                     {"put", "mary"},
+		`get "a b"`:                    {"get", "a b"},
+		`search "name:Al Bo"`:          {"search", "name:Al Bo"},
+	}
+	for line, expected := range tests {
+		args, err := parseLine(line)
+		if err != nil {
+			t.Errorf("parseLine(%q): %s", line, err)
+		} else if !reflect.DeepEqual(args, expected) {
+			t.Errorf("parseLine(%q) = %q, expected %q", line, args, expected)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	s := &shell{}
+	s.setCollection("users")
+	s.setCollection("groups")
+	s.remember("mary")
+	s.remember("mark")
+	s.remember("bob")
+
+	tests := map[string][]string{
+		"ed":        {"edit"},
+		"use u":     {"users"},
+		"get ma":    {"mark", "mary"},
+		"edit b":    {"bob"},
+		"search ma": nil,
+	}
+	for line, expected := range tests {
+		if matches := s.complete(line); !reflect.DeepEqual(matches, expected) {
+			t.Errorf("complete(%q) = %q, expected %q", line, matches, expected)
+		}
+	}
+
+	if prefix := commonPrefix([]string{"mark", "mary"}); prefix != "mar" {
+		t.Errorf("commonPrefix() = %q, expected \"mar\"", prefix)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// The codec used by clients that do not set one. Changing this impacts every
+// client without a codec of its own.
+var DefaultCodec Codec = &JSONCodec{}
+
+// Converts values to and from the JSON stored in Orchestrate. A client's
+// codec is used for every value written by Put, PutEvent,
+// PutRelationWithProperties and friends, and by the Value method of every
+// kind of result the client fetches. Orchestrate only stores JSON so
+// whatever a codec writes must be valid JSON.
+type Codec interface {
+	// Write the encoded form of value to w.
+	Encode(w io.Writer, value interface{}) error
+
+	// Decode data into the value pointed to by value.
+	Decode(data []byte, value interface{}) error
+}
+
+// A Codec using encoding/json. The zero value behaves exactly like
+// json.Marshal and json.Unmarshal.
+type JSONCodec struct {
+	// Fail to decode objects holding fields the destination does not have.
+	DisallowUnknownFields bool
+
+	// Decode numbers into interface{} values as json.Number rather than
+	// float64, so that large integers such as int64 IDs stay exact.
+	UseNumber bool
+
+	// Write <, > and & as is rather than escaping them for use in HTML.
+	DisableHTMLEscape bool
+}
+
+// Write the JSON encoding of value to w.
+func (j *JSONCodec) Encode(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(!j.DisableHTMLEscape)
+	return encoder.Encode(value)
+}
+
+// Decode JSON data into the value pointed to by value.
+func (j *JSONCodec) Decode(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if j.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if j.UseNumber {
+		decoder.UseNumber()
+	}
+	return decoder.Decode(value)
+}
+
+// Returns the codec the client should use.
+func (c *Client) codec() Codec {
+	if c.Codec != nil {
+		return c.Codec
+	}
+	return DefaultCodec
+}
+
+// Decode a value returned by Orchestrate, such as the RawValue of a result,
+// with the client's codec.
+//
+//	result, _ := c.Get("collection", "key")
+//	c.Decode(result.RawValue, &value)
+func (c *Client) Decode(data []byte, value interface{}) error {
+	return c.codec().Decode(data, value)
+}
+
+// Returns a reader that streams the encoded form of value.
+func (c *Client) encode(value interface{}) io.Reader {
+	codec := c.codec()
+	reader, writer := io.Pipe()
+
+	go func() { writer.CloseWithError(codec.Encode(writer, value)) }()
+	return reader
+}
+
+// Decodes a value with the given codec, or the default codec if it is nil.
+// Results carry the codec of the client that fetched them, results built by
+// hand have none.
+func decodeValue(codec Codec, data []byte, value interface{}) error {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	return codec.Decode(data, value)
+}
@@ -0,0 +1,132 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestJSONCodecEncode(t *testing.T) {
+	value := map[string]string{"html": "<a>&"}
+
+	c := &Client{}
+	data, err := ioutil.ReadAll(c.encode(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"html":"\u003ca\u003e\u0026"}`+"\n" {
+		t.Errorf("default codec wrote %s", data)
+	}
+
+	c.Codec = &JSONCodec{DisableHTMLEscape: true}
+	data, err = ioutil.ReadAll(c.encode(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"html":"<a>&"}`+"\n" {
+		t.Errorf("DisableHTMLEscape wrote %s", data)
+	}
+}
+
+func TestJSONCodecDecode(t *testing.T) {
+	data := []byte(`{"id": 9007199254740993, "extra": true}`)
+
+	c := &Client{Codec: &JSONCodec{UseNumber: true}}
+	var value map[string]interface{}
+	if err := c.Decode(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if value["id"] != json.Number("9007199254740993") {
+		t.Errorf("UseNumber decoded id as %#v", value["id"])
+	}
+
+	var strict struct {
+		ID int64 `json:"id"`
+	}
+	c.Codec = &JSONCodec{DisallowUnknownFields: true}
+	if err := c.Decode(data, &strict); err == nil {
+		t.Errorf("DisallowUnknownFields accepted an unknown field")
+	}
+
+	// Results built by hand decode with the default codec.
+	result := &KVResult{RawValue: data}
+	if err := result.Value(&strict); err != nil || strict.ID != 9007199254740993 {
+		t.Errorf("Value() decoded %d, %v", strict.ID, err)
+	}
+}
+
+func TestResultsUseClientCodec(t *testing.T) {
+	const value = `{"id": 9007199254740993}`
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v0/users/mary" {
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("Content-Location", "/v0/users/mary/refs/abc")
+			w.Write([]byte(value))
+			return
+		}
+		// Every listing shares the same shape.
+		w.Write([]byte(`{"count": 1, "results": [{"path": {"collection": "users", "key": "mary"}, "value": ` + value + `}]}`))
+	}))
+	defer server.Close()
+	c.Codec = &JSONCodec{UseNumber: true}
+
+	check := func(name string, decode func(interface{}) error) {
+		var decoded map[string]interface{}
+		if err := decode(&decoded); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if decoded["id"] != json.Number("9007199254740993") {
+			t.Errorf("%s decoded id as %#v", name, decoded["id"])
+		}
+	}
+
+	result, err := c.Get("users", "mary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Get", result.Value)
+
+	list, err := c.List("users", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("List", list.Results[0].Value)
+
+	search, err := c.Search("users", "id:*", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Search", search.Results[0].Value)
+
+	events, err := c.GetEvents("users", "mary", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("GetEvents", events.Results[0].Value)
+
+	relations, err := c.GetRelations("users", "mary", []string{"friend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("GetRelations", relations.Results[0].Value)
+
+	refs, err := c.ListRefs("users", "mary", 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("ListRefs", refs.Results[0].Value)
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The kinds of change found when comparing two values. These match the
+// operation names used by JSON patch.
+const (
+	DiffAdd     = "add"
+	DiffRemove  = "remove"
+	DiffReplace = "replace"
+)
+
+// A single change between two JSON documents.
+type Difference struct {
+	// One of DiffAdd, DiffRemove or DiffReplace.
+	Op string
+
+	// The location of the change as a JSON pointer (RFC 6901).
+	Path string
+
+	// The value before the change, nil for additions.
+	From interface{}
+
+	// The value after the change, nil for removals.
+	To interface{}
+}
+
+// The changes between two refs of an item.
+type RefDiff struct {
+	From    Path
+	To      Path
+	Changes []Difference
+}
+
+// Compare the values of an item at two refs.
+func (c *Client) DiffRefs(collection, key, fromRef, toRef string) (*RefDiff, error) {
+	from, err := c.GetRef(collection, key, fromRef)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.GetRef(collection, key, toRef)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := DiffJSON(from.RawValue, to.RawValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefDiff{From: from.Path, To: to.Path, Changes: changes}, nil
+}
+
+// Compare two JSON documents, returning the changes that turn from into to.
+// Numbers are compared exactly as written rather than as floats.
+func DiffJSON(from, to []byte) ([]Difference, error) {
+	a, err := decodeNumbers(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeNumbers(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Difference
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+// Returns a patch that applies the changes, turning the older value into the
+// newer one.
+func (d *RefDiff) PatchSet() PatchSet {
+	patch := make(PatchSet, 0, len(d.Changes))
+	for _, change := range d.Changes {
+		op := PatchOperation{Op: change.Op, Path: change.Path}
+		if change.Op != DiffRemove {
+			// A nil value would be dropped entirely rather than sent as null.
+			op.Value = change.To
+			if op.Value == nil {
+				op.Value = json.RawMessage("null")
+			}
+		}
+		patch = append(patch, op)
+	}
+	return patch
+}
+
+// Renders the changes one per line, prefixed with "+" for additions, "-"
+// for removals and "~" for replacements.
+func (d *RefDiff) String() string {
+	buf := bytes.NewBuffer(nil)
+	for _, change := range d.Changes {
+		switch change.Op {
+		case DiffAdd:
+			fmt.Fprintf(buf, "+ %s: %s\n", change.Path, diffText(change.To))
+		case DiffRemove:
+			fmt.Fprintf(buf, "- %s: %s\n", change.Path, diffText(change.From))
+		case DiffReplace:
+			fmt.Fprintf(buf, "~ %s: %s -> %s\n", change.Path, diffText(change.From), diffText(change.To))
+		}
+	}
+	return buf.String()
+}
+
+// Decodes a JSON document keeping numbers as json.Number.
+func decodeNumbers(data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Appends the changes between two decoded values at the given pointer.
+func diffValues(pointer string, from, to interface{}, changes *[]Difference) {
+	switch a := from.(type) {
+	case map[string]interface{}:
+		if b, ok := to.(map[string]interface{}); ok {
+			diffObjects(pointer, a, b, changes)
+			return
+		}
+	case []interface{}:
+		if b, ok := to.([]interface{}); ok {
+			diffArrays(pointer, a, b, changes)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, Difference{Op: DiffReplace, Path: pointer, From: from, To: to})
+	}
+}
+
+// Appends the changes between two objects, in key order.
+func diffObjects(pointer string, from, to map[string]interface{}, changes *[]Difference) {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := pointer + "/" + escapePointer(key)
+		a, inFrom := from[key]
+		b, inTo := to[key]
+		switch {
+		case !inTo:
+			*changes = append(*changes, Difference{Op: DiffRemove, Path: child, From: a})
+		case !inFrom:
+			*changes = append(*changes, Difference{Op: DiffAdd, Path: child, To: b})
+		default:
+			diffValues(child, a, b, changes)
+		}
+	}
+}
+
+// Appends the changes between two arrays. Elements are compared by position,
+// and removals are listed from the end so that each index is still valid
+// when the changes are applied in order.
+func diffArrays(pointer string, from, to []interface{}, changes *[]Difference) {
+	common := len(from)
+	if len(to) < common {
+		common = len(to)
+	}
+
+	for i := 0; i < common; i++ {
+		diffValues(pointer+"/"+strconv.Itoa(i), from[i], to[i], changes)
+	}
+	for i := common; i < len(to); i++ {
+		*changes = append(*changes, Difference{Op: DiffAdd, Path: pointer + "/" + strconv.Itoa(i), To: to[i]})
+	}
+	for i := len(from) - 1; i >= common; i-- {
+		*changes = append(*changes, Difference{Op: DiffRemove, Path: pointer + "/" + strconv.Itoa(i), From: from[i]})
+	}
+}
+
+// Escapes a key for use in a JSON pointer.
+func escapePointer(key string) string {
+	key = strings.Replace(key, "~", "~0", -1)
+	return strings.Replace(key, "/", "~1", -1)
+}
+
+// Renders a value within a diff as JSON.
+func diffText(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	from := []byte(`{"name": "a", "n": 9007199254740993, "gone": true, "tags": [1, 2, 3], "a/b": {"c": 1}}`)
+	to := []byte(`{"name": "b", "n": 9007199254740992, "new": null, "tags": [1, 5], "a/b": {"c": 1}}`)
+
+	changes, err := DiffJSON(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := &RefDiff{Changes: changes}
+	expected := "- /gone: true\n" +
+		"~ /n: 9007199254740993 -> 9007199254740992\n" +
+		"~ /name: \"a\" -> \"b\"\n" +
+		"+ /new: null\n" +
+		"~ /tags/1: 2 -> 5\n" +
+		"- /tags/2: 3\n"
+	if diff.String() != expected {
+		t.Errorf("String() =\n%s\nexpected:\n%s", diff.String(), expected)
+	}
+
+	patch, err := json.Marshal(diff.PatchSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPatch := `[{"op":"remove","path":"/gone"},` +
+		`{"op":"replace","path":"/n","value":9007199254740992},` +
+		`{"op":"replace","path":"/name","value":"b"},` +
+		`{"op":"add","path":"/new","value":null},` +
+		`{"op":"replace","path":"/tags/1","value":5},` +
+		`{"op":"remove","path":"/tags/2"}]`
+	if string(patch) != expectedPatch {
+		t.Errorf("PatchSet() =\n%s\nexpected:\n%s", patch, expectedPatch)
+	}
+}
+
+func TestDiffEscapePointer(t *testing.T) {
+	changes, err := DiffJSON([]byte(`{"a/b~c": 1}`), []byte(`{"a/b~c": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "/a~1b~0c" {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+}
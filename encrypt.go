@@ -0,0 +1,414 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Returned when an encrypted field can not be decrypted, either because it
+// was tampered with or because the key is wrong.
+var ErrDecryptionFailed = errors.New("gorc: unable to decrypt field")
+
+// The prefixes of encrypted field values. Each is followed by the key id, a
+// colon and the base64 encoded nonce and ciphertext.
+const (
+	encryptedPrefix     = "gorc:enc:v1:"
+	deterministicPrefix = "gorc:det:v1:"
+)
+
+// Supplies the AES keys used by an EncryptingCodec. Keys are 16, 24 or 32
+// bytes long, selecting AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// Returns the key new values are encrypted with along with its id. The
+	// id is stored next to each encrypted field and may not contain a colon.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Returns the key with the given id, used to decrypt fields encrypted
+	// before the current key was rotated in.
+	Key(id string) ([]byte, error)
+}
+
+// A KeyProvider holding a fixed set of keys. Rotate keys by adding a new one
+// and making it current; the old keys are still needed to read the values
+// they encrypted until those values are saved again.
+type StaticKeys struct {
+	// The id of the key new values are encrypted with.
+	Current string
+
+	// Every known key by id.
+	Keys map[string][]byte
+}
+
+// Returns the current key.
+func (s *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := s.Key(s.Current)
+	return s.Current, key, err
+}
+
+// Returns the key with the given id.
+func (s *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("Unknown encryption key %q", id)
+	}
+	return key, nil
+}
+
+// A Codec that encrypts selected struct fields with AES-GCM before they are
+// sent to Orchestrate, and decrypts them again in the Value methods of the
+// results a client fetches. Set it as the Codec of a client to use it. Fields
+// are selected with a "gorc" tag:
+//
+//	type User struct {
+//		Name  string `json:"name"`
+//		SSN   string `json:"ssn" gorc:"encrypt"`
+//		Email string `json:"email" gorc:"encrypt,deterministic"`
+//	}
+//
+// Each encrypted field is stored as a string holding the id of the key and
+// the ciphertext, with the field's JSON name as additional data so that
+// values can not be moved between fields. Only fields stored at the top level
+// of the JSON object can be encrypted, which includes the fields of embedded
+// structs. Encoding a struct with tagged fields anywhere deeper is an error
+// rather than storing them in plain text. Patch operations are sent as is.
+//
+// By default every encryption uses a random nonce so equal values produce
+// different ciphertexts. Deterministic fields derive the nonce from the
+// value instead, so equal values under the same key always produce the
+// same ciphertext and can be found with SearchTerm. This reveals which
+// records share a value, so only use it where equality search is needed.
+//
+// Decoding decrypts every encrypted field found, whatever the destination,
+// and leaves plain values alone so existing records can be read while they
+// are migrated.
+type EncryptingCodec struct {
+	// The source of encryption keys.
+	Keys KeyProvider
+
+	// The codec values are encoded and decoded with. If this is nil then
+	// DefaultCodec is used.
+	Codec Codec
+}
+
+// A field that is to be encrypted.
+type encryptedField struct {
+	name          string
+	deterministic bool
+}
+
+// Encode value with the inner codec, encrypting its tagged fields.
+func (e *EncryptingCodec) Encode(w io.Writer, value interface{}) error {
+	fields, err := encryptedFields(reflect.TypeOf(value))
+	if err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return e.inner().Encode(w, value)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := e.inner().Encode(buf, value); err != nil {
+		return err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &object); err != nil {
+		return err
+	}
+
+	id, key, err := e.Keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		plaintext, ok := object[field.name]
+		if !ok {
+			continue
+		}
+		sealed, err := seal(id, key, field.name, plaintext, field.deterministic)
+		if err != nil {
+			return err
+		}
+		object[field.name] = sealed
+	}
+
+	return e.inner().Encode(w, object)
+}
+
+// Decrypt any encrypted fields in data and decode it with the inner codec.
+func (e *EncryptingCodec) Decode(data []byte, value interface{}) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		// Not an object, so there is nothing to decrypt.
+		return e.inner().Decode(data, value)
+	}
+
+	opened := false
+	for name, raw := range object {
+		if !bytes.HasPrefix(raw, []byte(`"`+encryptedPrefix)) &&
+			!bytes.HasPrefix(raw, []byte(`"`+deterministicPrefix)) {
+			continue
+		}
+		plaintext, err := e.open(name, raw)
+		if err != nil {
+			return err
+		}
+		object[name] = plaintext
+		opened = true
+	}
+	if !opened {
+		return e.inner().Decode(data, value)
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(object); err != nil {
+		return err
+	}
+	return e.inner().Decode(buf.Bytes(), value)
+}
+
+// Returns the value a deterministic field holding value is stored as with
+// the current key, for use in an equality search. The field is named by its
+// JSON name. The term contains colons so quote it in the query:
+//
+//	term, _ := codec.SearchTerm("email", "mary@example.com")
+//	c.Search("users", fmt.Sprintf("email:%q", term), 10, 0)
+//
+// Records encrypted with an older key will not match until they are saved
+// again.
+func (e *EncryptingCodec) SearchTerm(field string, value interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := e.inner().Encode(buf, value); err != nil {
+		return "", err
+	}
+
+	id, key, err := e.Keys.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(id, key, field, buf.Bytes(), true)
+	if err != nil {
+		return "", err
+	}
+
+	var term string
+	err = json.Unmarshal(sealed, &term)
+	return term, err
+}
+
+// Returns the codec values are encoded and decoded with.
+func (e *EncryptingCodec) inner() Codec {
+	if e.Codec != nil {
+		return e.Codec
+	}
+	return DefaultCodec
+}
+
+// Decrypt a single field, returning its plaintext JSON.
+func (e *EncryptingCodec) open(name string, raw json.RawMessage) (json.RawMessage, error) {
+	var sealed string
+	if err := json.Unmarshal(raw, &sealed); err != nil {
+		return nil, err
+	}
+
+	// The prefix has been checked by the caller, leaving "<kid>:<data>".
+	rest := sealed[len(encryptedPrefix):]
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return nil, ErrDecryptionFailed
+	}
+	data, err := base64.RawURLEncoding.DecodeString(rest[i+1:])
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	key, err := e.Keys.Key(rest[:i])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// Encrypt the JSON plaintext of the named field, returning the JSON string
+// it is stored as.
+func seal(id string, key []byte, name string, plaintext []byte, deterministic bool) (json.RawMessage, error) {
+	if strings.Contains(id, ":") {
+		return nil, fmt.Errorf("Encryption key id %q may not contain a colon", id)
+	}
+
+	// Compact the plaintext so that equal values encrypt identically no
+	// matter how they were formatted.
+	compact := new(bytes.Buffer)
+	if err := json.Compact(compact, plaintext); err != nil {
+		return nil, err
+	}
+	plaintext = compact.Bytes()
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := encryptedPrefix
+	nonce := make([]byte, aead.NonceSize())
+	if deterministic {
+		prefix = deterministicPrefix
+		copy(nonce, deriveNonce(key, name, plaintext))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return json.Marshal(prefix + id + ":" + base64.RawURLEncoding.EncodeToString(data))
+}
+
+// Returns a nonce determined by the key, field name and plaintext. The MAC
+// key is derived from the encryption key rather than being the key itself.
+func deriveNonce(key []byte, name string, plaintext []byte) []byte {
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("gorc deterministic nonce"))
+
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
+
+// Returns an AES-GCM cipher for the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the fields of a struct type, or a pointer to one, that are tagged
+// for encryption. The fields of embedded structs are included since
+// encoding/json stores them at the top level too.
+func encryptedFields(typ reflect.Type) ([]encryptedField, error) {
+	typ = indirectType(typ)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	var fields []encryptedField
+	err := collectEncryptedFields(typ, &fields, make(map[reflect.Type]bool))
+	return fields, err
+}
+
+// Adds the tagged fields of a struct type to fields, descending into
+// embedded structs the way encoding/json does.
+func collectEncryptedFields(typ reflect.Type, fields *[]encryptedField, seen map[reflect.Type]bool) error {
+	if seen[typ] {
+		return nil
+	}
+	seen[typ] = true
+
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a name of their own have their fields
+		// promoted, even when the struct type itself is unexported.
+		if embedded := indirectType(f.Type); f.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			if err := collectEncryptedFields(embedded, fields, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		options := parseTag(f.Tag.Get("gorc"))
+		if _, ok := options["encrypt"]; !ok {
+			if hasEncryptedFields(f.Type, make(map[reflect.Type]bool)) {
+				return fmt.Errorf("Field %s.%s holds fields tagged for encryption below the top level, which can not be encrypted",
+					typ.Name(), f.Name)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		_, deterministic := options["deterministic"]
+		*fields = append(*fields, encryptedField{name: name, deterministic: deterministic})
+	}
+	return nil
+}
+
+// Returns true if values of a type hold struct fields tagged for encryption
+// at any depth.
+func hasEncryptedFields(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice ||
+		typ.Kind() == reflect.Array || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || seen[typ] {
+		return false
+	}
+	seen[typ] = true
+
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if _, ok := parseTag(f.Tag.Get("gorc"))["encrypt"]; ok {
+			return true
+		}
+		if hasEncryptedFields(f.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the type a pointer type points to, or the type itself.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
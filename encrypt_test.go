@@ -0,0 +1,193 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type encryptedUser struct {
+	Name  string `json:"name"`
+	SSN   string `json:"ssn" gorc:"encrypt"`
+	Email string `json:"email" gorc:"encrypt,deterministic"`
+	Age   int    `gorc:"encrypt"`
+}
+
+func encodeString(t *testing.T, codec Codec, value interface{}) string {
+	buf := new(bytes.Buffer)
+	if err := codec.Encode(buf, value); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestEncryptingCodec(t *testing.T) {
+	keys := &StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef")},
+	}
+	codec := &EncryptingCodec{Keys: keys}
+	user := &encryptedUser{Name: "Mary", SSN: "123-45-6789", Email: "mary@example.com", Age: 42}
+
+	first := encodeString(t, codec, user)
+	var stored, again map[string]string
+	if err := json.Unmarshal([]byte(first), &stored); err != nil {
+		t.Fatalf("Encoded value %s: %s", first, err)
+	}
+	json.Unmarshal([]byte(encodeString(t, codec, user)), &again)
+	for field, prefix := range map[string]string{"ssn": encryptedPrefix, "Age": encryptedPrefix, "email": deterministicPrefix} {
+		if !strings.HasPrefix(stored[field], prefix) {
+			t.Errorf("Field %s stored as %q", field, stored[field])
+		}
+	}
+	if stored["name"] != "Mary" {
+		t.Errorf("Unencrypted field stored as %q", stored["name"])
+	}
+	if stored["ssn"] == again["ssn"] {
+		t.Errorf("Randomized field encrypted the same way twice")
+	}
+	if stored["email"] != again["email"] {
+		t.Errorf("Deterministic field encrypted differently: %q, %q", stored["email"], again["email"])
+	}
+	if term, err := codec.SearchTerm("email", user.Email); err != nil || term != stored["email"] {
+		t.Errorf("SearchTerm() = %q, %v; expected %q", term, err, stored["email"])
+	}
+
+	// Rotating the key must not prevent reading older values.
+	keys.Keys["k2"] = []byte("fedcba9876543210fedcba9876543210")
+	keys.Current = "k2"
+
+	decoded := new(encryptedUser)
+	if err := codec.Decode([]byte(first), decoded); err != nil {
+		t.Fatal(err)
+	} else if *decoded != *user {
+		t.Errorf("Decoded %+v, expected %+v", decoded, user)
+	}
+
+	// Moving a value to another field must fail.
+	stored["ssn"], stored["email"] = stored["email"], stored["ssn"]
+	swapped, _ := json.Marshal(stored)
+	if err := codec.Decode(swapped, new(encryptedUser)); err != ErrDecryptionFailed {
+		t.Errorf("Decoding swapped fields returned %v", err)
+	}
+
+	// Plain values pass through untouched.
+	plain := new(encryptedUser)
+	if err := codec.Decode([]byte(`{"ssn": "plain"}`), plain); err != nil || plain.SSN != "plain" {
+		t.Errorf("Decoding a plain value gave %q, %v", plain.SSN, err)
+	}
+}
+
+// Embedded in test values. These are exported since encoding/json can only
+// allocate embedded pointers to exported types.
+type EncryptedPII struct {
+	SSN string `json:"ssn" gorc:"encrypt"`
+}
+
+type EncryptedContact struct {
+	Phone string `json:"phone" gorc:"encrypt,deterministic"`
+}
+
+func TestEncryptingCodecEmbedded(t *testing.T) {
+	codec := &EncryptingCodec{Keys: &StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef")},
+	}}
+
+	type person struct {
+		EncryptedPII
+		*EncryptedContact
+		Name string `json:"name"`
+	}
+	value := person{EncryptedPII{"123-45-6789"}, &EncryptedContact{"555-0100"}, "Mary"}
+
+	encoded := encodeString(t, codec, &value)
+	var stored map[string]string
+	if err := json.Unmarshal([]byte(encoded), &stored); err != nil {
+		t.Fatalf("Encoded value %s: %s", encoded, err)
+	}
+	if !strings.HasPrefix(stored["ssn"], encryptedPrefix) || !strings.HasPrefix(stored["phone"], deterministicPrefix) {
+		t.Errorf("Embedded fields were stored in plain text: %s", encoded)
+	}
+
+	decoded := person{}
+	if err := codec.Decode([]byte(encoded), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SSN != "123-45-6789" || decoded.EncryptedContact == nil || decoded.Phone != "555-0100" {
+		t.Errorf("Decoded %+v", decoded)
+	}
+
+	// Tagged fields in nested objects can not be encrypted.
+	nested := struct {
+		PII      EncryptedPII       `json:"pii"`
+		Contacts []EncryptedContact `json:"contacts"`
+	}{PII: EncryptedPII{"123-45-6789"}}
+	if err := codec.Encode(new(bytes.Buffer), nested); err == nil {
+		t.Error("Encode() accepted an encrypted field in a nested object")
+	}
+}
+
+func TestEncryptingCodecHTMLEscape(t *testing.T) {
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	value := struct {
+		Tag string `json:"<b>"`
+		SSN string `json:"ssn" gorc:"encrypt"`
+	}{"x", "123-45-6789"}
+
+	escaped := encodeString(t, &EncryptingCodec{Keys: keys}, value)
+	if !strings.Contains(escaped, `"\u003cb\u003e"`) {
+		t.Errorf("Default inner codec did not escape HTML: %s", escaped)
+	}
+
+	inner := &JSONCodec{DisableHTMLEscape: true}
+	unescaped := encodeString(t, &EncryptingCodec{Keys: keys, Codec: inner}, value)
+	if !strings.Contains(unescaped, `"<b>"`) {
+		t.Errorf("Inner codec's DisableHTMLEscape was ignored: %s", unescaped)
+	}
+}
+
+func TestEncryptingCodecClient(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+	c.Codec = &EncryptingCodec{Keys: &StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": []byte("0123456789abcdef")},
+	}}
+
+	user := &encryptedUser{Name: "Mary", SSN: "123-45-6789", Email: "mary@example.com", Age: 42}
+	if _, err := c.Put("users", "mary", user); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Get("users", "mary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]string
+	if json.Unmarshal(result.RawValue, &stored); !strings.HasPrefix(stored["ssn"], encryptedPrefix) {
+		t.Errorf("Stored %s", result.RawValue)
+	}
+
+	// Results decrypt with the client's codec, DefaultCodec is left alone.
+	decoded := new(encryptedUser)
+	if err := result.Value(decoded); err != nil {
+		t.Fatal(err)
+	} else if *decoded != *user {
+		t.Errorf("Value() decoded %+v, expected %+v", decoded, user)
+	}
+}
@@ -16,23 +16,97 @@ package gorc
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Holds results returned from an Events query.
 type EventResults struct {
 	Count   uint64  `json:"count"`
 	Results []Event `json:"results"`
+	Next    string  `json:"next,omitempty"`
 }
 
 // An individual event.
 type Event struct {
+	Path      EventPath       `json:"path"`
 	Ordinal   uint64          `json:"ordinal"`
 	Timestamp uint64          `json:"timestamp"`
 	RawValue  json.RawMessage `json:"value"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
+}
+
+// One end of a range of events. If Ordinal is zero the bound falls on the
+// timestamp itself rather than on a specific event.
+type EventBound struct {
+	Timestamp int64  `json:"timestamp"`
+	Ordinal   uint64 `json:"ordinal,omitempty"`
+}
+
+// A range of events to query. Bounds that are left nil are open, and at most
+// one of Start and After, and one of Before and End, should be set.
+type EventRange struct {
+	// Include events at or after this point.
+	Start *EventBound
+
+	// Include only events after this point.
+	After *EventBound
+
+	// Include only events before this point.
+	Before *EventBound
+
+	// Include events at or before this point.
+	End *EventBound
+
+	// The number of events fetched per request. Defaults to 100.
+	PageSize int
+
+	// Iterate from the oldest event to the newest. Orchestrate returns events
+	// newest first so iterating in this direction reads the entire range
+	// into memory before the first event is returned.
+	Ascending bool
+}
+
+// Iterates over every event in a range, fetching pages as needed.
+//
+//	iter := c.IterateEvents("collection", "key", "kind", nil)
+//	for iter.Next() {
+//		event := iter.Event()
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type EventIterator struct {
+	client     *Client
+	collection string
+	key        string
+	kind       string
+	query      EventRange
+
+	page    *EventResults
+	index   int
+	event   *Event
+	fetched bool
+	err     error
+}
+
+// A representation of an individual event's path within Orchestrate. An
+// event is identified by its timestamp, in milliseconds since the epoch, and
+// an ordinal that distinguishes events sharing the same timestamp.
+type EventPath struct {
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+	Kind       string `json:"type"`
+	Timestamp  uint64 `json:"timestamp"`
+	Ordinal    uint64 `json:"ordinal"`
+	Ref        string `json:"ref"`
 }
 
 // Get latest events of a particular type from specified collection-key pair.
@@ -42,8 +116,9 @@ func (c *Client) GetEvents(collection, key, kind string) (*EventResults, error)
 	return c.doGetEvents(trailingUri)
 }
 
-// Get all events of a particular type from specified collection-key pair in a
-// range.
+// Get events of a particular type from specified collection-key pair in a
+// range. This returns at most 10 events, use HasNext to check for more or
+// IterateEvents to walk the entire range.
 func (c *Client) GetEventsInRange(collection, key, kind string, start int64, end int64) (*EventResults, error) {
 	return c.GetEventsInRangeWithLimit(collection, key, kind, start, end, 10)
 }
@@ -61,41 +136,208 @@ func (c *Client) GetEventsInRangeWithLimit(collection, key, kind string, start,
 	return c.doGetEvents(trailingUri)
 }
 
-// Put an event of the specified type to provided collection-key pair.
-func (c *Client) PutEvent(collection, key, kind string, value interface{}) error {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
-
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutEventRaw(collection, key, kind, reader)
+// Like GetEventsInRange except the range is given as times.
+func (c *Client) GetEventsBetween(collection, key, kind string, start, end time.Time) (*EventResults, error) {
+	return c.GetEventsInRange(collection, key, kind, timeToMillis(start), timeToMillis(end))
 }
 
-// Put an event of the specified type to provided collection-key pair.
-func (c *Client) PutEventRaw(collection, key, kind string, value io.Reader) error {
+// Get a single page of events of a particular type from a collection-key pair
+// within a range. If the range is nil the latest events are returned.
+func (c *Client) GetEventsRange(collection, key, kind string, r *EventRange) (*EventResults, error) {
+	queryVariables := url.Values{}
+	if r != nil {
+		if r.PageSize > 0 {
+			queryVariables.Set("limit", strconv.Itoa(r.PageSize))
+		}
+		if r.Start != nil {
+			queryVariables.Set("startEvent", r.Start.String())
+		}
+		if r.After != nil {
+			queryVariables.Set("afterEvent", r.After.String())
+		}
+		if r.Before != nil {
+			queryVariables.Set("beforeEvent", r.Before.String())
+		}
+		if r.End != nil {
+			queryVariables.Set("endEvent", r.End.String())
+		}
+	}
+
 	trailingUri := collection + "/" + key + "/events/" + kind
+	if len(queryVariables) > 0 {
+		trailingUri += "?" + queryVariables.Encode()
+	}
 
-	return c.doPutEvent(trailingUri, value)
+	return c.doGetEvents(trailingUri)
+}
 
+// Get the page of event results that follow the provided set.
+func (c *Client) GetEventsGetNext(results *EventResults) (*EventResults, error) {
+	return c.doGetEvents(trailingFromLink(results.Next))
 }
 
-// Put an event of the specified type to provided collection-key pair and time.
-func (c *Client) PutEventWithTime(collection, key, kind string, time int64, value interface{}) error {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
+// Returns an iterator over every event of a particular type on a
+// collection-key pair within a range. If the range is nil every event is
+// returned, newest first.
+func (c *Client) IterateEvents(collection, key, kind string, r *EventRange) *EventIterator {
+	iter := &EventIterator{
+		client:     c,
+		collection: collection,
+		key:        key,
+		kind:       kind,
+	}
+	if r != nil {
+		iter.query = *r
+	}
+	if iter.query.PageSize <= 0 {
+		iter.query.PageSize = 100
+	}
+	return iter
+}
 
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutEventWithTimeRaw(collection, key, kind, time, reader)
+// Put an event of the specified type to provided collection-key pair. The
+// returned path identifies the event so that it can be updated later.
+func (c *Client) PutEvent(collection, key, kind string, value interface{}) (*EventPath, error) {
+	return c.PutEventRaw(collection, key, kind, c.encode(value))
 }
 
-// Put an event of the specified type to provided collection-key pair and time.
-func (c *Client) PutEventWithTimeRaw(collection, key, kind string, time int64, value io.Reader) error {
+// Put an event of the specified type to provided collection-key pair.
+func (c *Client) PutEventRaw(collection, key, kind string, value io.Reader) (*EventPath, error) {
+	path := &EventPath{Collection: collection, Key: key, Kind: kind}
+	trailingUri := collection + "/" + key + "/events/" + kind
+
+	return c.doCreateEvent("PUT", path, trailingUri, 204, value)
+}
+
+// Put an event of the specified type to provided collection-key pair and
+// time, given in milliseconds since the epoch.
+func (c *Client) PutEventWithTime(collection, key, kind string, timestamp int64, value interface{}) (*EventPath, error) {
+	return c.PutEventWithTimeRaw(collection, key, kind, timestamp, c.encode(value))
+}
+
+// Put an event of the specified type to provided collection-key pair and
+// time, given in milliseconds since the epoch.
+func (c *Client) PutEventWithTimeRaw(collection, key, kind string, timestamp int64, value io.Reader) (*EventPath, error) {
 	queryVariables := url.Values{
-		"timestamp": []string{strconv.FormatInt(time, 10)},
+		"timestamp": []string{strconv.FormatInt(timestamp, 10)},
 	}
 
+	path := &EventPath{Collection: collection, Key: key, Kind: kind, Timestamp: uint64(timestamp)}
 	trailingUri := collection + "/" + key + "/events/" + kind + "?" + queryVariables.Encode()
 
-	return c.doPutEvent(trailingUri, value)
+	return c.doCreateEvent("PUT", path, trailingUri, 204, value)
+}
+
+// Put an event of the specified type to provided collection-key pair at the
+// given time. Event times have millisecond precision.
+func (c *Client) PutEventAt(collection, key, kind string, t time.Time, value interface{}) (*EventPath, error) {
+	return c.PutEventWithTime(collection, key, kind, timeToMillis(t), value)
+}
+
+// Put an event of the specified type to provided collection-key pair at the
+// given time. Event times have millisecond precision.
+func (c *Client) PutEventAtRaw(collection, key, kind string, t time.Time, value io.Reader) (*EventPath, error) {
+	return c.PutEventWithTimeRaw(collection, key, kind, timeToMillis(t), value)
+}
+
+// Create an event of the specified type on the provided collection-key pair,
+// letting the server assign the timestamp and ordinal.
+func (c *Client) PostEvent(collection, key, kind string, value interface{}) (*EventPath, error) {
+	return c.PostEventRaw(collection, key, kind, c.encode(value))
+}
+
+// Create an event of the specified type on the provided collection-key pair,
+// letting the server assign the timestamp and ordinal.
+func (c *Client) PostEventRaw(collection, key, kind string, value io.Reader) (*EventPath, error) {
+	path := &EventPath{Collection: collection, Key: key, Kind: kind}
+	trailingUri := collection + "/" + key + "/events/" + kind
+
+	return c.doCreateEvent("POST", path, trailingUri, 201, value)
+}
+
+// Get a single event by its timestamp and ordinal.
+func (c *Client) GetEvent(collection, key, kind string, timestamp, ordinal uint64) (*Event, error) {
+	return c.GetEventPath(&EventPath{
+		Collection: collection,
+		Key:        key,
+		Kind:       kind,
+		Timestamp:  timestamp,
+		Ordinal:    ordinal,
+	})
+}
+
+// Get the event at a path.
+func (c *Client) GetEventPath(path *EventPath) (*Event, error) {
+	resp, err := c.doRequest("GET", path.trailingURI(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// If the request ended in error then read the body into an
+	// OrchestrateError object.
+	if resp.StatusCode != 200 {
+		return nil, newError(resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	event := &Event{codec: c.codec()}
+	if err := decoder.Decode(event); err != nil {
+		return nil, err
+	}
+
+	// Fill in anything the body left out from what we asked for.
+	if event.Path.Collection == "" {
+		event.Path = *path
+	}
+	if event.Path.Ref == "" {
+		event.Path.Ref = refFromETag(resp.Header.Get("ETag"))
+	}
+	event.Timestamp = event.Path.Timestamp
+	event.Ordinal = event.Path.Ordinal
+
+	return event, nil
+}
+
+// Replace the value of an existing event.
+func (c *Client) UpdateEvent(path *EventPath, value interface{}) (*EventPath, error) {
+	return c.UpdateEventRaw(path, c.encode(value))
+}
+
+// Replace the value of an existing event.
+func (c *Client) UpdateEventRaw(path *EventPath, value io.Reader) (*EventPath, error) {
+	return c.doUpdateEvent(path, nil, value)
+}
+
+// Replace the value of an existing event if the path's ref value is the
+// latest.
+func (c *Client) UpdateEventIfUnmodified(path *EventPath, value interface{}) (*EventPath, error) {
+	return c.UpdateEventIfUnmodifiedRaw(path, c.encode(value))
+}
+
+// Replace the value of an existing event if the path's ref value is the
+// latest.
+func (c *Client) UpdateEventIfUnmodifiedRaw(path *EventPath, value io.Reader) (*EventPath, error) {
+	headers := map[string]string{
+		"If-Match": `"` + path.Ref + `"`,
+	}
+
+	return c.doUpdateEvent(path, headers, value)
+}
+
+// Delete an event. Orchestrate does not keep a history of events so this
+// always purges the event entirely.
+func (c *Client) DeleteEvent(path *EventPath) error {
+	return c.doDelete(path.trailingURI()+"?purge=true", nil)
+}
+
+// Delete an event if the path's ref value is the latest.
+func (c *Client) DeleteEventIfUnmodified(path *EventPath) error {
+	headers := map[string]string{
+		"If-Match": `"` + path.Ref + `"`,
+	}
+
+	return c.doDelete(path.trailingURI()+"?purge=true", headers)
 }
 
 // Execute event get.
@@ -118,32 +360,229 @@ func (c *Client) doGetEvents(trailingUri string) (*EventResults, error) {
 	if err = decoder.Decode(results); err != nil {
 		return nil, err
 	}
+	for i := range results.Results {
+		results.Results[i].codec = c.codec()
+	}
 
 	return results, err
 }
 
-// Execute event put.
-func (c *Client) doPutEvent(trailingUri string, value io.Reader) error {
-	resp, err := c.doRequest("PUT", trailingUri, nil, value)
+// Execute an event creation, returning the path of the new event. The
+// timestamp and ordinal are taken from the Location header and the ref from
+// the ETag header, without a Location the event can not be addressed so that
+// is an error.
+func (c *Client) doCreateEvent(method string, path *EventPath, trailingUri string, status int, value io.Reader) (*EventPath, error) {
+	resp, err := c.doRequest(method, trailingUri, nil, value)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// If the request ended in error then read the body into an
+	// OrchestrateError object.
+	if resp.StatusCode != status {
+		return nil, newError(resp)
+	}
+
+	// Read the body so the connection can be properly reused.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	timestamp, ordinal, err := eventFromLocation(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := *path
+	result.Ref = refFromETag(resp.Header.Get("ETag"))
+	result.Timestamp = timestamp
+	result.Ordinal = ordinal
+	return &result, nil
+}
+
+// Advance to the next event, returning false once the range is exhausted or
+// an error occurs.
+func (i *EventIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+
+	if i.page == nil || i.index >= len(i.page.Results) {
+		if !i.fetch() {
+			return false
+		}
+	}
+
+	i.event = &i.page.Results[i.index]
+	i.index++
+	return true
+}
+
+// The event the iterator is positioned on.
+func (i *EventIterator) Event() *Event {
+	return i.event
+}
+
+// Any error encountered while iterating.
+func (i *EventIterator) Err() error {
+	return i.err
+}
+
+// Loads the next page of events, returning false if there are none.
+func (i *EventIterator) fetch() bool {
+	if i.query.Ascending {
+		// Everything is read on the first fetch.
+		if i.fetched {
+			return false
+		}
+
+		all := &EventResults{}
+		for i.fetchDescending() {
+			all.Results = append(all.Results, i.page.Results...)
+		}
+		if i.err != nil {
+			return false
+		}
+		for l, r := 0, len(all.Results)-1; l < r; l, r = l+1, r-1 {
+			all.Results[l], all.Results[r] = all.Results[r], all.Results[l]
+		}
+		all.Count = uint64(len(all.Results))
+
+		i.page, i.index = all, 0
+		return len(all.Results) > 0
+	}
+
+	return i.fetchDescending()
+}
+
+// Loads the next page of events in the order Orchestrate returns them.
+func (i *EventIterator) fetchDescending() bool {
+	var page *EventResults
+	var err error
+
+	switch {
+	case !i.fetched:
+		i.fetched = true
+		page, err = i.client.GetEventsRange(i.collection, i.key, i.kind, &i.query)
+	case i.page.HasNext():
+		page, err = i.client.GetEventsGetNext(i.page)
+	case len(i.page.Results) >= i.query.PageSize:
+		// Without a link to the next page we continue from just before the
+		// oldest event seen so far.
+		last := i.page.Results[len(i.page.Results)-1]
+		i.query.End = nil
+		i.query.Before = &EventBound{Timestamp: int64(last.Timestamp), Ordinal: last.Ordinal}
+		page, err = i.client.GetEventsRange(i.collection, i.key, i.kind, &i.query)
+	default:
+		return false
+	}
+
+	if err != nil {
+		i.err = err
+		return false
+	}
+
+	i.page, i.index = page, 0
+	return len(page.Results) > 0
+}
+
+// Check if there is a subsequent page of event results.
+func (r *EventResults) HasNext() bool {
+	return r.Next != ""
+}
+
+// Formats the bound as used in event range queries.
+func (b *EventBound) String() string {
+	if b.Ordinal == 0 {
+		return strconv.FormatInt(b.Timestamp, 10)
+	}
+	return strconv.FormatInt(b.Timestamp, 10) + "/" + strconv.FormatUint(b.Ordinal, 10)
+}
+
+// Returns a bound that falls on the given time.
+func EventBoundAt(t time.Time) *EventBound {
+	return &EventBound{Timestamp: timeToMillis(t)}
+}
+
+// Execute an update of an existing event.
+func (c *Client) doUpdateEvent(path *EventPath, headers map[string]string, value io.Reader) (*EventPath, error) {
+	resp, err := c.doRequest("PUT", path.trailingURI(), headers, value)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// If the request ended in error then read the body into an
 	// OrchestrateError object.
 	if resp.StatusCode != 204 {
-		return newError(resp)
+		return nil, newError(resp)
 	}
 
 	// Read the body so the connection can be properly reused.
 	io.Copy(ioutil.Discard, resp.Body)
 
-	// Success
-	return nil
+	// The event keeps its identity, only the ref changes.
+	result := *path
+	result.Ref = refFromETag(resp.Header.Get("ETag"))
+	return &result, nil
+}
+
+// Extracts the timestamp and ordinal from the Location header of a created
+// event, which takes the form "/v0/collection/key/events/kind/ts/ordinal".
+func eventFromLocation(location string) (uint64, uint64, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return 0, 0, err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if n := len(parts); n >= 4 && parts[n-4] == "events" {
+		timestamp, err := strconv.ParseUint(parts[n-2], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid event timestamp: %s", location)
+		}
+		ordinal, err := strconv.ParseUint(parts[n-1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid event ordinal: %s", location)
+		}
+		return timestamp, ordinal, nil
+	}
+	return 0, 0, fmt.Errorf("Missing event component: %s", location)
+}
+
+// Returns the trailing URI part for requests on an individual event.
+func (p *EventPath) trailingURI() string {
+	return p.Collection + "/" + p.Key + "/events/" + p.Kind + "/" +
+		strconv.FormatUint(p.Timestamp, 10) + "/" +
+		strconv.FormatUint(p.Ordinal, 10)
 }
 
 // Marshall the value of an event into the provided object.
 func (r *Event) Value(value interface{}) error {
-	return json.Unmarshal(r.RawValue, value)
+	return decodeValue(r.codec, r.RawValue, value)
+}
+
+// The time of the event.
+func (r *Event) Time() time.Time {
+	return millisToTime(int64(r.Timestamp))
+}
+
+// The time of the event.
+func (p *EventPath) Time() time.Time {
+	return millisToTime(int64(p.Timestamp))
+}
+
+// The time of the bound.
+func (b *EventBound) Time() time.Time {
+	return millisToTime(b.Timestamp)
+}
+
+// Converts a time to the milliseconds since the epoch that Orchestrate uses
+// for timestamps. This and millisToTime are the only places the conversion
+// is made, everything else should go through them.
+func timeToMillis(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+}
+
+// Converts milliseconds since the epoch to a time.
+func millisToTime(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
 }
@@ -0,0 +1,203 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEventPathTrailingUri(t *testing.T) {
+	path := &EventPath{
+		Collection: "users",
+		Key:        "mary",
+		Kind:       "activities",
+		Timestamp:  1398286518286,
+		Ordinal:    6,
+	}
+
+	expected := "users/mary/events/activities/1398286518286/6"
+	if uri := path.trailingURI(); uri != expected {
+		t.Errorf("trailingURI() = %q, expected %q", uri, expected)
+	}
+}
+
+func TestEventFromLocation(t *testing.T) {
+	timestamp, ordinal, err := eventFromLocation("/v0/users/mary/events/activities/1398286518286/6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timestamp != 1398286518286 || ordinal != 6 {
+		t.Errorf("eventFromLocation() = %d, %d, expected 1398286518286, 6", timestamp, ordinal)
+	}
+
+	for _, location := range []string{"", "/v0/users/mary", "/v0/users/mary/events/activities/abc/6"} {
+		if _, _, err := eventFromLocation(location); err == nil {
+			t.Errorf("eventFromLocation(%q) expected an error", location)
+		}
+	}
+}
+
+func TestEventBoundString(t *testing.T) {
+	if s := (&EventBound{Timestamp: 1398286518286}).String(); s != "1398286518286" {
+		t.Errorf("String() = %q, expected the bare timestamp", s)
+	}
+	if s := (&EventBound{Timestamp: 1398286518286, Ordinal: 6}).String(); s != "1398286518286/6" {
+		t.Errorf("String() = %q, expected timestamp/ordinal", s)
+	}
+}
+
+func TestEventTimeConversion(t *testing.T) {
+	now := time.Unix(1398286518, 286999999)
+	if ms := timeToMillis(now); ms != 1398286518286 {
+		t.Errorf("timeToMillis() = %d, expected 1398286518286", ms)
+	}
+
+	event := &Event{Timestamp: 1398286518286}
+	if !event.Time().Equal(time.Unix(1398286518, 286000000)) {
+		t.Errorf("Time() = %s", event.Time())
+	}
+	if ms := timeToMillis(event.Time()); ms != 1398286518286 {
+		t.Errorf("Round trip = %d, expected 1398286518286", ms)
+	}
+}
+
+func TestEventIteratorAscendingError(t *testing.T) {
+	// Point at an unroutable host so the first request fails.
+	c := NewClient("")
+	c.APIHost = "127.0.0.1:0"
+
+	iter := c.IterateEvents("users", "mary", "activities", &EventRange{Ascending: true})
+	if iter.Next() {
+		t.Fatal("Next() returned an event")
+	}
+	if iter.Err() == nil {
+		t.Error("Err() is nil after a failed request")
+	}
+}
+
+func TestPutEventRequiresLocation(t *testing.T) {
+	location := ""
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if location != "" {
+			w.Header().Set("Location", location)
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.WriteHeader(201)
+	}))
+	defer server.Close()
+
+	if path, err := c.PostEventRaw("users", "mary", "login", nil); err == nil {
+		t.Errorf("PostEventRaw() without a Location = %+v, expected an error", path)
+	}
+
+	location = "/v0/users/mary/events/login/1398286518286/3"
+	path, err := c.PostEventRaw("users", "mary", "login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Timestamp != 1398286518286 || path.Ordinal != 3 || path.Ref != "abc" {
+		t.Errorf("PostEventRaw() = %+v", path)
+	}
+}
+
+func TestGetEventPath(t *testing.T) {
+	body := `{"timestamp": 1398286518286, "ordinal": 3, "value": {"name": "Mary"}}`
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/users/mary/events/login/1398286518286/3" {
+			t.Errorf("Requested %s", r.URL.Path)
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	// A body without a path is filled in from the request and the ETag.
+	event, err := c.GetEvent("users", "mary", "login", 1398286518286, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := EventPath{Collection: "users", Key: "mary", Kind: "login", Timestamp: 1398286518286, Ordinal: 3, Ref: "abc"}
+	if event.Path != expected || event.Timestamp != 1398286518286 || event.Ordinal != 3 {
+		t.Errorf("GetEvent() = %+v", event)
+	}
+	var value map[string]string
+	if err := event.Value(&value); err != nil || value["name"] != "Mary" {
+		t.Errorf("Value() = %v, %v", value, err)
+	}
+
+	// A path in the body is used as is.
+	body = `{"path": {"collection": "users", "key": "mary", "type": "login", "timestamp": 1398286518286, "ordinal": 3, "ref": "def"}, "value": {}}`
+	if event, err := c.GetEventPath(&expected); err != nil || event.Path.Ref != "def" {
+		t.Errorf("GetEventPath() = %+v, %v", event, err)
+	}
+}
+
+func TestUpdateEventIfUnmodified(t *testing.T) {
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v0/users/mary/events/login/1398286518286/3" {
+			t.Errorf("Request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("If-Match") != `"abc"` {
+			w.WriteHeader(412)
+			w.Write([]byte(`{"message": "ref mismatch"}`))
+			return
+		}
+		w.Header().Set("ETag", `"def"`)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	path := &EventPath{Collection: "users", Key: "mary", Kind: "login", Timestamp: 1398286518286, Ordinal: 3, Ref: "abc"}
+	updated, err := c.UpdateEventIfUnmodified(path, map[string]string{"name": "Mary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Ref != "def" || updated.Timestamp != path.Timestamp || updated.Ordinal != path.Ordinal {
+		t.Errorf("UpdateEventIfUnmodified() = %+v", updated)
+	}
+
+	// The old ref no longer matches.
+	if _, err := c.UpdateEventIfUnmodified(&EventPath{Collection: "users", Key: "mary", Kind: "login",
+		Timestamp: 1398286518286, Ordinal: 3, Ref: "old"}, 1); !hasStatus(err, 412) {
+		t.Errorf("UpdateEventIfUnmodified() with a stale ref = %v", err)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	var requests []string
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String()+" "+r.Header.Get("If-Match"))
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	path := &EventPath{Collection: "users", Key: "mary", Kind: "login", Timestamp: 1398286518286, Ordinal: 3, Ref: "abc"}
+	if err := c.DeleteEvent(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEventIfUnmodified(path); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"DELETE /v0/users/mary/events/login/1398286518286/3?purge=true ",
+		`DELETE /v0/users/mary/events/login/1398286518286/3?purge=true "abc"`,
+	}
+	if len(requests) != 2 || requests[0] != expected[0] || requests[1] != expected[1] {
+		t.Errorf("Requests %q, expected %q", requests, expected)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// The types of record written to an export stream.
+const (
+	// The current value of an item.
+	ExportItem = "item"
+
+	// A historical value, or tombstone, of an item.
+	ExportRef = "ref"
+
+	// An event attached to an item.
+	ExportEvent = "event"
+
+	// A relationship from an item to another item.
+	ExportRelation = "relation"
+)
+
+// A single line of an export stream. Every record names its type and the
+// item it belongs to so that a stream can be read without any other context.
+type ExportRecord struct {
+	// One of ExportItem, ExportRef, ExportEvent or ExportRelation.
+	Type string `json:"type"`
+
+	// The item this record belongs to. For relations this is the source.
+	Path Path `json:"path"`
+
+	// The time a ref was created, in milliseconds since the epoch.
+	RefTime uint64 `json:"reftime,omitempty"`
+
+	// The event or relation type.
+	Kind string `json:"kind,omitempty"`
+
+	// The time and ordinal of an event.
+	Timestamp uint64 `json:"timestamp,omitempty"`
+	Ordinal   uint64 `json:"ordinal,omitempty"`
+
+	// The destination of a relation.
+	To *Path `json:"to,omitempty"`
+
+	// The value of an item, ref or event.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Options that control what is included in an export.
+type ExportOptions struct {
+	// Only export items with keys that sort after this one. Set this to the
+	// key returned from a failed export to resume it.
+	AfterKey string
+
+	// The number of items fetched per page. Defaults to 100.
+	PageSize int
+
+	// Include the full ref history of each item.
+	Refs bool
+
+	// The event types exported for each item.
+	EventKinds []string
+
+	// The relation types exported for each item.
+	RelationKinds []string
+}
+
+// Export every item in a collection to w as JSON Lines, one ExportRecord per
+// line. Each item is written followed by its refs, events and relations.
+//
+// The returned key is the last item to have been completely written, even if
+// an error is returned, so the export can be resumed by passing it back in as
+// AfterKey.
+func (c *Client) Export(w io.Writer, collection string, opts *ExportOptions) (string, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	limit := opts.PageSize
+	if limit <= 0 {
+		limit = 100
+	}
+
+	encoder := json.NewEncoder(w)
+	lastKey := opts.AfterKey
+
+	var results *KVResults
+	var err error
+	if lastKey == "" {
+		results, err = c.List(collection, limit)
+	} else {
+		results, err = c.ListAfter(collection, lastKey, limit)
+	}
+
+	for {
+		if err != nil {
+			return lastKey, err
+		}
+
+		for i := range results.Results {
+			if err := c.exportItem(encoder, &results.Results[i], opts); err != nil {
+				return lastKey, err
+			}
+			lastKey = results.Results[i].Path.Key
+		}
+
+		if !results.HasNext() {
+			return lastKey, nil
+		}
+		results, err = c.ListGetNext(results)
+	}
+}
+
+// Write the full history of an item to w as JSON Lines, one ExportRecord of
+// type ExportRef for every version and deletion along with its reftime.
+func (c *Client) ExportAuditTrail(w io.Writer, collection, key string, oldestFirst bool) error {
+	return c.exportRefs(json.NewEncoder(w), Path{Collection: collection, Key: key}, oldestFirst)
+}
+
+// Writes a single item and everything attached to it.
+func (c *Client) exportItem(encoder *json.Encoder, item *KVResult, opts *ExportOptions) error {
+	path := item.Path
+
+	err := encoder.Encode(&ExportRecord{
+		Type:    ExportItem,
+		Path:    path,
+		RefTime: item.RefTime,
+		Value:   item.RawValue,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.Refs {
+		if err := c.exportRefs(encoder, path, false); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range opts.EventKinds {
+		if err := c.exportEvents(encoder, path, kind); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range opts.RelationKinds {
+		iter := c.IterateRelations(path.Collection, path.Key, []string{kind}, 100)
+		for iter.Next() {
+			err := encoder.Encode(&ExportRecord{
+				Type: ExportRelation,
+				Path: Path{Collection: path.Collection, Key: path.Key},
+				Kind: kind,
+				To:   &iter.Result().Path,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes every ref of an item.
+func (c *Client) exportRefs(encoder *json.Encoder, path Path, oldestFirst bool) error {
+	iter := c.IterateRefs(path.Collection, path.Key, true, oldestFirst)
+	for iter.Next() {
+		ref := iter.Result()
+		err := encoder.Encode(&ExportRecord{
+			Type:    ExportRef,
+			Path:    ref.Path,
+			RefTime: ref.RefTime,
+			Value:   ref.RawValue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// Writes every event of one type attached to an item.
+func (c *Client) exportEvents(encoder *json.Encoder, path Path, kind string) error {
+	iter := c.IterateEvents(path.Collection, path.Key, kind, nil)
+	for iter.Next() {
+		event := iter.Event()
+		err := encoder.Encode(&ExportRecord{
+			Type:      ExportEvent,
+			Path:      Path{Collection: path.Collection, Key: path.Key},
+			Kind:      kind,
+			Timestamp: event.Timestamp,
+			Ordinal:   event.Ordinal,
+			Value:     event.RawValue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
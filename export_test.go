@@ -0,0 +1,89 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the type and key of every record in an export, and the value of
+// each event.
+func readExport(t *testing.T, data []byte) []string {
+	var records []string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		record := new(ExportRecord)
+		if err := decoder.Decode(record); err != nil {
+			t.Fatal(err)
+		}
+		description := record.Type + " " + record.Path.Key
+		switch record.Type {
+		case ExportEvent:
+			description += " " + string(record.Value)
+		case ExportRelation:
+			description += " " + record.Kind + " " + record.To.Key
+		}
+		records = append(records, strings.TrimSpace(description))
+	}
+	return records
+}
+
+func TestExport(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	start := time.Date(2014, 4, 23, 0, 0, 0, 0, time.UTC)
+	fake.Write("users", "a", `{"name":"Al"}`, start)
+	fake.Write("users", "a", `{"name":"Alan"}`, start.Add(time.Hour))
+	fake.Write("users", "b", `{"name":"Bo"}`, start)
+	fake.Write("users", "c", `{"name":"Cy"}`, start)
+	c.PostEvent("users", "a", "login", 1)
+	c.PostEvent("users", "a", "login", 2)
+	c.PutRelation("users", "a", "friend", "users", "c")
+
+	// Two items per page splits the collection over two pages.
+	opts := &ExportOptions{PageSize: 2, Refs: true, EventKinds: []string{"login"}, RelationKinds: []string{"friend"}}
+	buf := new(bytes.Buffer)
+	lastKey, err := c.Export(buf, "users", opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if lastKey != "c" {
+		t.Errorf("Export() returned %q, expected the last key", lastKey)
+	}
+
+	expected := []string{
+		"item a", "ref a", "ref a", "event a 2", "event a 1", "relation a friend c",
+		"item b", "ref b",
+		"item c", "ref c",
+	}
+	if records := readExport(t, buf.Bytes()); strings.Join(records, "|") != strings.Join(expected, "|") {
+		t.Errorf("Exported %q, expected %q", records, expected)
+	}
+
+	// Resuming after a key picks up with the next one.
+	buf.Reset()
+	opts.AfterKey = "a"
+	if _, err := c.Export(buf, "users", opts); err != nil {
+		t.Fatal(err)
+	}
+	if records := readExport(t, buf.Bytes()); strings.Join(records, "|") != "item b|ref b|item c|ref c" {
+		t.Errorf("Resumed export wrote %q", records)
+	}
+}
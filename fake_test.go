@@ -0,0 +1,424 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An in memory stand in for the key/value, ref, event and relation parts of
+// Orchestrate.
+type fakeOrchestrate struct {
+	lock      sync.Mutex
+	items     map[string][]fakeRef
+	events    map[string][]Event
+	relations map[string]fakeRef
+	ordinal   uint64
+	refs      int
+}
+
+// One version or deletion of an item, or the properties of a relation.
+type fakeRef struct {
+	ref       string
+	value     []byte
+	time      int64
+	tombstone bool
+}
+
+func newFakeOrchestrate() *fakeOrchestrate {
+	return &fakeOrchestrate{
+		items:     make(map[string][]fakeRef),
+		events:    make(map[string][]Event),
+		relations: make(map[string]fakeRef),
+	}
+}
+
+// Writes a version of an item at the given time and returns its ref.
+func (f *fakeOrchestrate) Write(collection, key, value string, at time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.write(collection+"/"+key, fakeRef{value: []byte(value), time: timeToMillis(at)})
+}
+
+// Deletes an item at the given time, leaving a tombstone, and returns the
+// tombstone's ref.
+func (f *fakeOrchestrate) Remove(collection, key string, at time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.write(collection+"/"+key, fakeRef{time: timeToMillis(at), tombstone: true})
+}
+
+// Returns the current value of an item and whether it exists.
+func (f *fakeOrchestrate) Value(collection, key string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	current, ok := f.current(collection + "/" + key)
+	return string(current.value), ok
+}
+
+// Returns the number of versions and deletions of an item.
+func (f *fakeOrchestrate) Refs(collection, key string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.items[collection+"/"+key])
+}
+
+// Returns the events stored under a collection-key pair and type, oldest
+// first.
+func (f *fakeOrchestrate) Events(collection, key, kind string) []Event {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]Event(nil), f.events[collection+"/"+key+"/"+kind]...)
+}
+
+// Appends a ref to the history of an item.
+func (f *fakeOrchestrate) write(id string, ref fakeRef) string {
+	f.refs++
+	ref.ref = "ref" + strconv.Itoa(f.refs)
+	f.items[id] = append(f.items[id], ref)
+	return ref.ref
+}
+
+// Returns the latest version of an item, if it exists.
+func (f *fakeOrchestrate) current(id string) (fakeRef, bool) {
+	history := f.items[id]
+	if len(history) == 0 || history[len(history)-1].tombstone {
+		return fakeRef{}, false
+	}
+	return history[len(history)-1], true
+}
+
+func (f *fakeOrchestrate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		f.serveList(w, r, parts[0])
+	case len(parts) == 2:
+		f.serveItem(w, r, parts[0], parts[1])
+	case len(parts) == 4 && parts[2] == "refs" && r.Method == "GET":
+		f.serveRefs(w, r, parts[0]+"/"+parts[1], parts[3])
+	case len(parts) == 4 && parts[2] == "events":
+		f.serveEvents(w, r, parts[0], parts[1], parts[3])
+	case len(parts) == 6 && parts[2] == "relation":
+		f.serveRelation(w, r, strings.Join(parts, "/"))
+	case len(parts) == 4 && parts[2] == "relations" && r.Method == "GET":
+		f.serveRelations(w, r, parts[0]+"/"+parts[1]+"/relation/"+parts[3]+"/")
+	default:
+		f.fail(w, 404)
+	}
+}
+
+func (f *fakeOrchestrate) serveItem(w http.ResponseWriter, r *http.Request, collection, key string) {
+	id := collection + "/" + key
+	current, exists := f.current(id)
+
+	if !f.checkConditions(w, r, current, exists) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !exists {
+			f.fail(w, 404)
+			return
+		}
+		w.Header().Set("ETag", `"`+current.ref+`"`)
+		w.Header().Set("Content-Location", "/v0/"+id+"/refs/"+current.ref)
+		w.Header().Set("Last-Modified", millisToTime(current.time).UTC().Format(http.TimeFormat))
+		w.Write(current.value)
+
+	case "PUT":
+		value, _ := ioutil.ReadAll(r.Body)
+		ref := f.write(id, fakeRef{value: value, time: timeToMillis(time.Now())})
+		w.Header().Set("ETag", `"`+ref+`"`)
+		w.Header().Set("Location", "/v0/"+id+"/refs/"+ref)
+		w.WriteHeader(201)
+
+	case "DELETE":
+		if r.URL.Query().Get("purge") == "true" {
+			delete(f.items, id)
+		} else if exists {
+			f.write(id, fakeRef{time: timeToMillis(time.Now()), tombstone: true})
+		}
+		w.WriteHeader(204)
+	}
+}
+
+// Applies the If-Match and If-None-Match headers of a write, answering 412
+// and returning false if they do not hold.
+func (f *fakeOrchestrate) checkConditions(w http.ResponseWriter, r *http.Request, current fakeRef, exists bool) bool {
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != `"`+current.ref+`"`) {
+		f.fail(w, 412)
+		return false
+	}
+	if r.Header.Get("If-None-Match") != "" && exists {
+		f.fail(w, 412)
+		return false
+	}
+	return true
+}
+
+// Lists the items of a collection in key order.
+func (f *fakeOrchestrate) serveList(w http.ResponseWriter, r *http.Request, collection string) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+
+	var keys []string
+	for id := range f.items {
+		if _, ok := f.current(id); ok && strings.HasPrefix(id, collection+"/") {
+			if key := strings.TrimPrefix(id, collection+"/"); key > query.Get("afterKey") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	results := &KVResults{Results: []KVResult{}}
+	for _, key := range keys {
+		if len(results.Results) == limit {
+			next := url.Values{"limit": {strconv.Itoa(limit)}, "afterKey": {results.Results[limit-1].Path.Key}}
+			results.Next = "/v0/" + collection + "?" + next.Encode()
+			break
+		}
+		current, _ := f.current(collection + "/" + key)
+		results.Results = append(results.Results, KVResult{
+			Path:     Path{Collection: collection, Key: key, Ref: current.ref},
+			RawValue: current.value,
+			RefTime:  uint64(current.time),
+		})
+	}
+	results.Count = uint64(len(results.Results))
+	json.NewEncoder(w).Encode(results)
+}
+
+// Serves a single ref of an item, or its history newest first if ref is
+// empty. Deletions have no value to get, and items that never existed have
+// no history.
+func (f *fakeOrchestrate) serveRefs(w http.ResponseWriter, r *http.Request, id, ref string) {
+	history := f.items[id]
+	parts := strings.SplitN(id, "/", 2)
+	if len(history) == 0 {
+		f.fail(w, 404)
+		return
+	}
+
+	if ref != "" {
+		for _, version := range history {
+			if version.ref == ref && !version.tombstone {
+				w.Header().Set("ETag", `"`+version.ref+`"`)
+				w.Header().Set("Content-Location", "/v0/"+id+"/refs/"+version.ref)
+				w.Write(version.value)
+				return
+			}
+		}
+		f.fail(w, 404)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	values := query.Get("values") == "true"
+
+	results := &RefResults{Results: []RefResult{}}
+	for i := len(history) - 1 - offset; i >= 0; i-- {
+		if len(results.Results) == limit {
+			next := url.Values{
+				"limit":  {strconv.Itoa(limit)},
+				"offset": {strconv.Itoa(offset + limit)},
+				"values": {strconv.FormatBool(values)},
+			}
+			results.Next = "/v0/" + id + "/refs/?" + next.Encode()
+			break
+		}
+		version := history[i]
+		result := RefResult{
+			Path:    Path{Collection: parts[0], Key: parts[1], Ref: version.ref, Tombstone: version.tombstone},
+			RefTime: uint64(version.time),
+		}
+		if values && !version.tombstone {
+			result.RawValue = version.value
+		}
+		results.Results = append(results.Results, result)
+	}
+	results.Count = uint64(len(results.Results))
+	json.NewEncoder(w).Encode(results)
+}
+
+func (f *fakeOrchestrate) serveEvents(w http.ResponseWriter, r *http.Request, collection, key, kind string) {
+	id := collection + "/" + key + "/" + kind
+	query := r.URL.Query()
+
+	if r.Method == "POST" || r.Method == "PUT" {
+		value, _ := ioutil.ReadAll(r.Body)
+		timestamp := uint64(timeToMillis(time.Now()))
+		if ts := query.Get("timestamp"); ts != "" {
+			timestamp, _ = strconv.ParseUint(ts, 10, 64)
+		}
+		f.ordinal++
+
+		path := EventPath{Collection: collection, Key: key, Kind: kind,
+			Timestamp: timestamp, Ordinal: f.ordinal, Ref: "ref" + strconv.FormatUint(f.ordinal, 10)}
+		event := Event{Path: path, Timestamp: timestamp, Ordinal: f.ordinal, RawValue: value}
+		events := append(f.events[id], event)
+		sort.SliceStable(events, func(i, j int) bool { return eventBefore(&events[i], &events[j]) })
+		f.events[id] = events
+
+		w.Header().Set("ETag", `"`+path.Ref+`"`)
+		w.Header().Set("Location", "/v0/"+path.trailingURI())
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+		} else {
+			w.WriteHeader(204)
+		}
+		return
+	}
+
+	// Unset bounds have the widest possible ordinal, so that a bare
+	// timestamp covers every event at that time.
+	bound := func(name string, ordinal uint64) (uint64, uint64, bool) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, 0, false
+		}
+		fields := strings.SplitN(value, "/", 2)
+		timestamp, _ := strconv.ParseUint(fields[0], 10, 64)
+		if len(fields) == 2 {
+			ordinal, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+		return timestamp, ordinal, true
+	}
+	compare := func(e *Event, timestamp, ordinal uint64) int {
+		switch {
+		case e.Timestamp != timestamp:
+			return int(e.Timestamp) - int(timestamp)
+		case e.Ordinal < ordinal:
+			return -1
+		case e.Ordinal > ordinal:
+			return 1
+		}
+		return 0
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		limit = 10
+	}
+
+	results := &EventResults{Results: []Event{}}
+	events := f.events[id]
+	for i := len(events) - 1; i >= 0 && len(results.Results) < limit; i-- {
+		e := &events[i]
+		if ts, ord, ok := bound("startEvent", 0); ok && compare(e, ts, ord) < 0 {
+			continue
+		}
+		if ts, ord, ok := bound("afterEvent", ^uint64(0)); ok && compare(e, ts, ord) <= 0 {
+			continue
+		}
+		if ts, ord, ok := bound("beforeEvent", 0); ok && compare(e, ts, ord) >= 0 {
+			continue
+		}
+		if ts, ord, ok := bound("endEvent", ^uint64(0)); ok && compare(e, ts, ord) > 0 {
+			continue
+		}
+		results.Results = append(results.Results, *e)
+	}
+	results.Count = uint64(len(results.Results))
+	json.NewEncoder(w).Encode(results)
+}
+
+// Serves a single relation, identified by its source, kind and destination.
+func (f *fakeOrchestrate) serveRelation(w http.ResponseWriter, r *http.Request, id string) {
+	current, exists := f.relations[id]
+	if !f.checkConditions(w, r, current, exists) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !exists {
+			f.fail(w, 404)
+			return
+		}
+		w.Header().Set("ETag", `"`+current.ref+`"`)
+		w.Write(current.value)
+
+	case "PUT":
+		value, _ := ioutil.ReadAll(r.Body)
+		f.refs++
+		ref := "ref" + strconv.Itoa(f.refs)
+		f.relations[id] = fakeRef{ref: ref, value: value}
+		w.Header().Set("ETag", `"`+ref+`"`)
+		w.WriteHeader(204)
+
+	case "DELETE":
+		delete(f.relations, id)
+		w.WriteHeader(204)
+	}
+}
+
+// Lists the destinations of the relations whose ids start with prefix.
+func (f *fakeOrchestrate) serveRelations(w http.ResponseWriter, r *http.Request, prefix string) {
+	var ids []string
+	for id := range f.relations {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	results := &GraphResults{Results: []GraphResult{}}
+	for _, id := range ids {
+		destination := strings.TrimPrefix(id, prefix)
+		current, _ := f.current(destination)
+		parts := strings.SplitN(destination, "/", 2)
+		results.Results = append(results.Results, GraphResult{
+			Path:     Path{Collection: parts[0], Key: parts[1], Ref: current.ref},
+			RawValue: current.value,
+		})
+	}
+	results.Count = uint64(len(results.Results))
+	json.NewEncoder(w).Encode(results)
+}
+
+func (f *fakeOrchestrate) fail(w http.ResponseWriter, status int) {
+	w.WriteHeader(status)
+	w.Write([]byte(`{"message": "` + http.StatusText(status) + `"}`))
+}
+
+// Orders events by timestamp and then ordinal.
+func eventBefore(a, b *Event) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.Ordinal < b.Ordinal
+}
@@ -15,9 +15,12 @@
 package gorc
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -25,19 +28,113 @@ import (
 type GraphResults struct {
 	Count   uint64        `json:"count"`
 	Results []GraphResult `json:"results"`
+	Next    string        `json:"next,omitempty"`
+	Prev    string        `json:"prev,omitempty"`
 }
 
 // An individual graph result.
 type GraphResult struct {
 	Path     Path            `json:"path"`
 	RawValue json.RawMessage `json:"value"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
+}
+
+// Iterates over every result of a relations query, fetching pages as needed.
+//
+//	iter := c.IterateRelations("collection", "key", []string{"kind"}, 100)
+//	for iter.Next() {
+//		result := iter.Result()
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type RelationIterator struct {
+	client     *Client
+	collection string
+	key        string
+	hops       []string
+	limit      int
+	offset     int
+
+	page    *GraphResults
+	index   int
+	result  *GraphResult
+	fetched bool
+	err     error
+}
+
+// A representation of a relationship's path within Orchestrate.
+type RelationPath struct {
+	Source      Path   `json:"source"`
+	Kind        string `json:"relation"`
+	Destination Path   `json:"destination"`
+	Ref         string `json:"ref"`
+}
+
+// An individual relationship and the properties stored on it.
+type RelationResult struct {
+	Path     RelationPath    `json:"path"`
+	RawValue json.RawMessage `json:"value"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
 }
 
 // Get all related key/value objects by collection-key and a list of relations.
+// Only the first page of results is returned, use HasNext to check for more.
 func (c *Client) GetRelations(collection, key string, hops []string) (*GraphResults, error) {
 	relationsPath := strings.Join(hops, "/")
 
 	trailingUri := collection + "/" + key + "/relations/" + relationsPath
+
+	return c.doGetRelations(trailingUri)
+}
+
+// Get related key/value objects by collection-key and a list of relations
+// with the specified page size and offset.
+func (c *Client) GetRelationsWithLimit(collection, key string, hops []string, limit, offset int) (*GraphResults, error) {
+	queryVariables := url.Values{
+		"limit":  []string{strconv.Itoa(limit)},
+		"offset": []string{strconv.Itoa(offset)},
+	}
+
+	relationsPath := strings.Join(hops, "/")
+
+	trailingUri := collection + "/" + key + "/relations/" + relationsPath + "?" + queryVariables.Encode()
+
+	return c.doGetRelations(trailingUri)
+}
+
+// Get the page of relation results that follow the provided set.
+func (c *Client) GetRelationsGetNext(results *GraphResults) (*GraphResults, error) {
+	return c.doGetRelations(trailingFromLink(results.Next))
+}
+
+// Get the page of relation results that precede the provided set.
+func (c *Client) GetRelationsGetPrev(results *GraphResults) (*GraphResults, error) {
+	return c.doGetRelations(trailingFromLink(results.Prev))
+}
+
+// Returns an iterator over every object related to a collection-key by a list
+// of relations, fetching pageSize results at a time. If pageSize is zero or
+// less then 100 is used.
+func (c *Client) IterateRelations(collection, key string, hops []string, pageSize int) *RelationIterator {
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	return &RelationIterator{
+		client:     c,
+		collection: collection,
+		key:        key,
+		hops:       hops,
+		limit:      pageSize,
+	}
+}
+
+// Execute a relations query.
+func (c *Client) doGetRelations(trailingUri string) (*GraphResults, error) {
 	resp, err := c.doRequest("GET", trailingUri, nil, nil)
 	if err != nil {
 		return nil, err
@@ -55,29 +152,110 @@ func (c *Client) GetRelations(collection, key string, hops []string) (*GraphResu
 	if err := decoder.Decode(result); err != nil {
 		return nil, err
 	}
+	for i := range result.Results {
+		result.Results[i].codec = c.codec()
+	}
 
 	return result, nil
 }
 
+// Get a single relationship between two collection-keys along with its
+// properties.
+func (c *Client) GetRelation(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) (*RelationResult, error) {
+	path := newRelationPath(sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+	resp, err := c.doRequest("GET", path.trailingURI(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// If the response was an error we return an OrchestrateError object.
+	if resp.StatusCode != 200 {
+		return nil, newError(resp)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+
+	path.Ref = refFromETag(resp.Header.Get("ETag"))
+	return &RelationResult{Path: *path, RawValue: buf.Bytes(), codec: c.codec()}, nil
+}
+
 // Create a relationship of a specified type between two collection-keys.
 func (c *Client) PutRelation(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) error {
-	trailingUri := sourceCollection + "/" + sourceKey + "/relation/" + kind + "/" + sinkCollection + "/" + sinkKey
-	resp, err := c.doRequest("PUT", trailingUri, nil, nil)
+	path := newRelationPath(sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+	_, err := c.doPutRelation(path, nil, nil)
+	return err
+}
+
+// Create a relationship of a specified type between two collection-keys that
+// holds the given properties.
+func (c *Client) PutRelationWithProperties(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string, value interface{}) (*RelationPath, error) {
+	return c.PutRelationWithPropertiesRaw(sourceCollection, sourceKey, kind, sinkCollection, sinkKey, c.encode(value))
+}
+
+// Create a relationship of a specified type between two collection-keys that
+// holds the given properties.
+func (c *Client) PutRelationWithPropertiesRaw(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string, value io.Reader) (*RelationPath, error) {
+	path := newRelationPath(sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+	return c.doPutRelation(path, nil, value)
+}
+
+// Create a relationship of a specified type between two collection-keys if
+// it doesn't already exist.
+func (c *Client) PutRelationIfAbsent(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string, value interface{}) (*RelationPath, error) {
+	return c.PutRelationIfAbsentRaw(sourceCollection, sourceKey, kind, sinkCollection, sinkKey, c.encode(value))
+}
+
+// Create a relationship of a specified type between two collection-keys if
+// it doesn't already exist.
+func (c *Client) PutRelationIfAbsentRaw(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string, value io.Reader) (*RelationPath, error) {
+	headers := map[string]string{
+		"If-None-Match": "\"*\"",
+	}
+
+	path := newRelationPath(sourceCollection, sourceKey, kind, sinkCollection, sinkKey)
+	return c.doPutRelation(path, headers, value)
+}
+
+// Update the properties of a relationship if the path's ref value is the
+// latest.
+func (c *Client) PutRelationIfUnmodified(path *RelationPath, value interface{}) (*RelationPath, error) {
+	return c.PutRelationIfUnmodifiedRaw(path, c.encode(value))
+}
+
+// Update the properties of a relationship if the path's ref value is the
+// latest.
+func (c *Client) PutRelationIfUnmodifiedRaw(path *RelationPath, value io.Reader) (*RelationPath, error) {
+	headers := map[string]string{
+		"If-Match": `"` + path.Ref + `"`,
+	}
+
+	return c.doPutRelation(path, headers, value)
+}
+
+// Execute a relationship put.
+func (c *Client) doPutRelation(path *RelationPath, headers map[string]string, value io.Reader) (*RelationPath, error) {
+	resp, err := c.doRequest("PUT", path.trailingURI(), headers, value)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// If the response was an error we return an OrchestrateError object which
 	// reads the body.
-	if resp.StatusCode != 204 {
-		return newError(resp)
+	if resp.StatusCode != 201 && resp.StatusCode != 204 {
+		return nil, newError(resp)
 	}
 
 	// Otherwise we need to read it ourselves.
 	io.Copy(ioutil.Discard, resp.Body)
 
-	return nil
+	result := *path
+	result.Ref = refFromETag(resp.Header.Get("ETag"))
+	return &result, nil
 }
 
 // Create a relationship of a specified type between two collection-keys.
@@ -101,7 +279,94 @@ func (c *Client) DeleteRelation(sourceCollection string, sourceKey string, kind
 	return nil
 }
 
+// Check if there is a subsequent page of relation results.
+func (r *GraphResults) HasNext() bool {
+	return r.Next != ""
+}
+
+// Check if there is a previous page of relation results.
+func (r *GraphResults) HasPrev() bool {
+	return r.Prev != ""
+}
+
+// Advance to the next result, returning false once there are no more or an
+// error occurs.
+func (i *RelationIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+
+	if i.page == nil || i.index >= len(i.page.Results) {
+		if !i.fetch() {
+			return false
+		}
+	}
+
+	i.result = &i.page.Results[i.index]
+	i.index++
+	return true
+}
+
+// The result the iterator is positioned on.
+func (i *RelationIterator) Result() *GraphResult {
+	return i.result
+}
+
+// Any error encountered while iterating.
+func (i *RelationIterator) Err() error {
+	return i.err
+}
+
+// Loads the next page of results, returning false if there are none.
+func (i *RelationIterator) fetch() bool {
+	var page *GraphResults
+	var err error
+
+	switch {
+	case !i.fetched:
+		i.fetched = true
+		page, err = i.client.GetRelationsWithLimit(i.collection, i.key, i.hops, i.limit, i.offset)
+	case i.page.HasNext():
+		i.offset += len(i.page.Results)
+		page, err = i.client.GetRelationsGetNext(i.page)
+	case len(i.page.Results) >= i.limit:
+		// Without a link to the next page we step the offset ourselves.
+		i.offset += len(i.page.Results)
+		page, err = i.client.GetRelationsWithLimit(i.collection, i.key, i.hops, i.limit, i.offset)
+	default:
+		return false
+	}
+
+	if err != nil {
+		i.err = err
+		return false
+	}
+
+	i.page, i.index = page, 0
+	return len(page.Results) > 0
+}
+
 // Marshall the value of a GraphResult into the provided object.
 func (r *GraphResult) Value(value interface{}) error {
-	return json.Unmarshal(r.RawValue, value)
+	return decodeValue(r.codec, r.RawValue, value)
+}
+
+// Marshall the properties of a relationship into the provided object.
+func (r *RelationResult) Value(value interface{}) error {
+	return decodeValue(r.codec, r.RawValue, value)
+}
+
+// Returns the path of the relationship between two collection-keys.
+func newRelationPath(sourceCollection, sourceKey, kind, sinkCollection, sinkKey string) *RelationPath {
+	return &RelationPath{
+		Source:      Path{Collection: sourceCollection, Key: sourceKey},
+		Kind:        kind,
+		Destination: Path{Collection: sinkCollection, Key: sinkKey},
+	}
+}
+
+// Returns the trailing URI part for requests on an individual relationship.
+func (p *RelationPath) trailingURI() string {
+	return p.Source.Collection + "/" + p.Source.Key + "/relation/" + p.Kind + "/" +
+		p.Destination.Collection + "/" + p.Destination.Key
 }
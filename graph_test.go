@@ -0,0 +1,97 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRelationPathTrailingUri(t *testing.T) {
+	path := newRelationPath("users", "mary", "friend", "users", "bob")
+
+	expected := "users/mary/relation/friend/users/bob"
+	if uri := path.trailingURI(); uri != expected {
+		t.Errorf("trailingURI() = %q, expected %q", uri, expected)
+	}
+}
+
+func TestGraphHasNext(t *testing.T) {
+	results := &GraphResults{}
+	if results.HasNext() || results.HasPrev() {
+		t.Error("Empty results claim to have more pages")
+	}
+
+	results.Next = "/v0/users/mary/relations/friend?limit=10&offset=10"
+	results.Prev = "/v0/users/mary/relations/friend?limit=10&offset=0"
+	if !results.HasNext() || !results.HasPrev() {
+		t.Error("Linked results claim to have no more pages")
+	}
+}
+
+func TestRelationProperties(t *testing.T) {
+	fake := newFakeOrchestrate()
+	var conditions []string
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			conditions = append(conditions, r.Header.Get("If-None-Match")+r.Header.Get("If-Match"))
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	created, err := c.PutRelationIfAbsent("users", "mary", "friend", "users", "bob", map[string]int{"since": 2014})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PutRelationIfAbsent("users", "mary", "friend", "users", "bob", nil); !hasStatus(err, 412) {
+		t.Errorf("PutRelationIfAbsent() of an existing relation = %v", err)
+	}
+
+	// The ref comes from the ETag and the body holds the properties.
+	result, err := c.GetRelation("users", "mary", "friend", "users", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var properties map[string]int
+	if result.Path.Ref != created.Ref || result.Path.Destination.Key != "bob" {
+		t.Errorf("GetRelation() path = %+v, expected ref %q", result.Path, created.Ref)
+	}
+	if err := result.Value(&properties); err != nil || properties["since"] != 2014 {
+		t.Errorf("GetRelation() properties = %v, %v", properties, err)
+	}
+
+	updated, err := c.PutRelationIfUnmodified(&result.Path, map[string]int{"since": 2015})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PutRelationIfUnmodified(&result.Path, nil); !hasStatus(err, 412) {
+		t.Errorf("PutRelationIfUnmodified() with a stale ref = %v", err)
+	}
+
+	expected := []string{`"*"`, `"*"`, `"` + created.Ref + `"`, `"` + created.Ref + `"`}
+	if len(conditions) != len(expected) {
+		t.Fatalf("Sent conditions %q, expected %q", conditions, expected)
+	}
+	for i := range expected {
+		if conditions[i] != expected[i] {
+			t.Errorf("Sent conditions %q, expected %q", conditions, expected)
+			break
+		}
+	}
+	if updated.Ref == created.Ref {
+		t.Error("PutRelationIfUnmodified() kept the old ref")
+	}
+}
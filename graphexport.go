@@ -0,0 +1,194 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The file formats a graph can be exported as.
+type GraphFormat int
+
+const (
+	// Graphviz DOT: http://www.graphviz.org/content/dot-language
+	GraphDOT GraphFormat = iota
+
+	// GraphML: http://graphml.graphdrawing.org/
+	GraphML
+)
+
+// Options that control which part of the graph is exported and how nodes
+// are labelled.
+type GraphExportOptions struct {
+	// The relation types followed from every node.
+	Kinds []string
+
+	// The number of hops walked from the start nodes. Defaults to one.
+	MaxDepth int
+
+	// Fields of each node's value to use as its label, as dot separated
+	// paths such as "name.first". The first field holding a value is used,
+	// and if none do the node is labelled with its collection and key.
+	LabelFields []string
+
+	// Decides whether a node is included. If nil every node is included.
+	Filter func(node *TraversalNode) bool
+}
+
+// A relationship found while walking the graph for export.
+type exportEdge struct {
+	from, to string
+	kind     string
+}
+
+// Walk the relationships around the given items and write the resulting
+// subgraph to w in the given format.
+func (c *Client) ExportGraph(w io.Writer, format GraphFormat, starts []Path, opts *GraphExportOptions) error {
+	if opts == nil {
+		opts = &GraphExportOptions{}
+	}
+	depth := opts.MaxDepth
+	if depth <= 0 {
+		depth = 1
+	}
+
+	var nodes []*TraversalNode
+	var edges []exportEdge
+	t := &Traversal{
+		Kinds:    opts.Kinds,
+		MaxDepth: depth,
+		Filter:   opts.Filter,
+		Edge: func(from, to *TraversalNode, kind string) {
+			edges = append(edges, exportEdge{nodeID(&from.Path), nodeID(&to.Path), kind})
+		},
+	}
+	err := c.Traverse(starts, t, func(node *TraversalNode) error {
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	switch format {
+	case GraphDOT:
+		writeDOT(buf, nodes, edges, opts.LabelFields)
+	case GraphML:
+		writeGraphML(buf, nodes, edges, opts.LabelFields)
+	default:
+		return fmt.Errorf("Unknown graph format: %d", format)
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Writes a graph in the Graphviz DOT language.
+func writeDOT(buf *bytes.Buffer, nodes []*TraversalNode, edges []exportEdge, fields []string) {
+	buf.WriteString("digraph gorc {\n")
+	for _, node := range nodes {
+		fmt.Fprintf(buf, "\t%s [label=%s];\n",
+			dotQuote(nodeID(&node.Path)), dotQuote(nodeLabel(node, fields)))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "\t%s -> %s [label=%s];\n",
+			dotQuote(edge.from), dotQuote(edge.to), dotQuote(edge.kind))
+	}
+	buf.WriteString("}\n")
+}
+
+// Writes a graph as GraphML.
+func writeGraphML(buf *bytes.Buffer, nodes []*TraversalNode, edges []exportEdge, fields []string) {
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	buf.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <key id="collection" for="node" attr.name="collection" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <key id="key" for="node" attr.name="key" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <key id="kind" for="edge" attr.name="kind" attr.type="string"/>` + "\n")
+	buf.WriteString(`  <graph id="gorc" edgedefault="directed">` + "\n")
+	for _, node := range nodes {
+		fmt.Fprintf(buf, "    <node id=\"%s\">\n", xmlEscape(nodeID(&node.Path)))
+		fmt.Fprintf(buf, "      <data key=\"label\">%s</data>\n", xmlEscape(nodeLabel(node, fields)))
+		fmt.Fprintf(buf, "      <data key=\"collection\">%s</data>\n", xmlEscape(node.Path.Collection))
+		fmt.Fprintf(buf, "      <data key=\"key\">%s</data>\n", xmlEscape(node.Path.Key))
+		buf.WriteString("    </node>\n")
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(edge.from), xmlEscape(edge.to))
+		fmt.Fprintf(buf, "      <data key=\"kind\">%s</data>\n", xmlEscape(edge.kind))
+		buf.WriteString("    </edge>\n")
+	}
+	buf.WriteString("  </graph>\n</graphml>\n")
+}
+
+// Returns the label for a node, taken from the first of the fields that
+// holds a simple value.
+func nodeLabel(node *TraversalNode, fields []string) string {
+	if len(fields) > 0 && len(node.RawValue) > 0 {
+		var value interface{}
+		if err := node.Value(&value); err == nil {
+			for _, field := range fields {
+				if label, ok := lookupField(value, field); ok {
+					return label
+				}
+			}
+		}
+	}
+	return nodeID(&node.Path)
+}
+
+// Finds a dot separated field within a decoded JSON value, returning it as a
+// string if it is a simple, non empty value.
+func lookupField(value interface{}, field string) (string, bool) {
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value = object[name]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case float64, bool:
+		return fmt.Sprint(v), true
+	case json.Number:
+		// Decoded by a codec using UseNumber.
+		return v.String(), true
+	}
+	return "", false
+}
+
+// Quotes a string as a DOT identifier.
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// Escapes a string for use in XML text or attributes.
+func xmlEscape(s string) string {
+	buf := bytes.NewBuffer(nil)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
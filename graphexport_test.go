@@ -0,0 +1,62 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestGraphExportDOT(t *testing.T) {
+	nodes := []*TraversalNode{
+		{Path: Path{Collection: "users", Key: "a"}, RawValue: []byte(`{"name": {"first": "Al \"Bo\""}}`)},
+		{Path: Path{Collection: "users", Key: "b"}, RawValue: []byte(`{"age": 3}`)},
+	}
+	edges := []exportEdge{{from: "users/a", to: "users/b", kind: "friend"}}
+
+	buf := bytes.NewBuffer(nil)
+	writeDOT(buf, nodes, edges, []string{"name.first", "nickname"})
+
+	expected := "digraph gorc {\n" +
+		"\t\"users/a\" [label=\"Al \\\"Bo\\\"\"];\n" +
+		"\t\"users/b\" [label=\"users/b\"];\n" +
+		"\t\"users/a\" -> \"users/b\" [label=\"friend\"];\n" +
+		"}\n"
+	if buf.String() != expected {
+		t.Errorf("writeDOT() wrote:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestGraphExportLookupField(t *testing.T) {
+	value := map[string]interface{}{
+		"name":  map[string]interface{}{"first": "Al"},
+		"age":   float64(3),
+		"id":    json.Number("9007199254740993"),
+		"empty": "",
+	}
+
+	tests := map[string]string{"name.first": "Al", "age": "3", "id": "9007199254740993"}
+	for field, expected := range tests {
+		if label, ok := lookupField(value, field); !ok || label != expected {
+			t.Errorf("lookupField(%q) = %q, %v, expected %q", field, label, ok, expected)
+		}
+	}
+	for _, field := range []string{"name", "empty", "missing", "age.years"} {
+		if label, ok := lookupField(value, field); ok {
+			t.Errorf("lookupField(%q) = %q, expected no label", field, label)
+		}
+	}
+}
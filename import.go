@@ -0,0 +1,244 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Controls what happens when an imported item already exists.
+type ImportMode int
+
+const (
+	// Replace existing items with the imported value.
+	ImportOverwrite ImportMode = iota
+
+	// Leave existing items untouched.
+	ImportSkipExisting
+
+	// Stop the import with an ImportConflictError.
+	ImportFailOnConflict
+)
+
+// The actions reported for each record of a dry run import.
+const (
+	ImportCreate   = "create"
+	ImportUpdate   = "update"
+	ImportSkip     = "skip"
+	ImportConflict = "conflict"
+)
+
+// Options that control how an export stream is imported.
+type ImportOptions struct {
+	// Restore into this collection rather than the one named in each record.
+	// Relations between items of the original collection are moved along
+	// with them.
+	Collection string
+
+	// What to do with items that already exist.
+	Mode ImportMode
+
+	// Report what would change without writing anything.
+	DryRun bool
+}
+
+// A change that an import made, or would make in a dry run.
+type ImportChange struct {
+	// One of ImportCreate, ImportUpdate, ImportSkip or ImportConflict.
+	Action string
+
+	// The record being imported, with its collection already remapped.
+	Record ExportRecord
+}
+
+// A summary of an import.
+type ImportReport struct {
+	// The number of items stored.
+	Items uint64
+
+	// The number of records left alone because their item already existed.
+	// This includes the events and relations exported with skipped items.
+	Skipped uint64
+
+	// The number of events and relations created.
+	Events    uint64
+	Relations uint64
+
+	// The number of records that can not be imported, such as refs, since
+	// the history of an item can not be rewritten.
+	Ignored uint64
+
+	// Every change that would be made. This is only populated for dry runs.
+	Changes []ImportChange
+}
+
+// Returned when an item being imported with ImportFailOnConflict exists.
+type ImportConflictError struct {
+	Path Path
+}
+
+// Read an export stream, as written by Export, and store its items, events
+// and relations. The report covers everything processed before any error.
+func (c *Client) Import(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	// The item most recently skipped. Its events and relations follow it in
+	// the stream and are skipped too, since they already exist as well.
+	var skipping *Path
+
+	report := &ImportReport{}
+	decoder := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		record := ExportRecord{}
+		if err := decoder.Decode(&record); err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, fmt.Errorf("Invalid record %d: %s", n, err)
+		}
+
+		remapRecord(&record, opts.Collection)
+		if err := c.importRecord(&record, opts, report, &skipping); err != nil {
+			return report, err
+		}
+	}
+}
+
+// Moves a record, and relations within its collection, to a new collection.
+func remapRecord(record *ExportRecord, collection string) {
+	if collection == "" {
+		return
+	}
+	if record.To != nil && record.To.Collection == record.Path.Collection {
+		to := *record.To
+		to.Collection = collection
+		record.To = &to
+	}
+	record.Path.Collection = collection
+}
+
+// Imports a single record, updating the report. Skipping holds the item
+// that was last skipped, if any, and is updated for item records.
+func (c *Client) importRecord(record *ExportRecord, opts *ImportOptions, report *ImportReport, skipping **Path) error {
+	path := record.Path
+
+	if record.Type == ExportEvent || record.Type == ExportRelation {
+		if s := *skipping; s != nil && s.Collection == path.Collection && s.Key == path.Key {
+			if opts.DryRun {
+				report.Changes = append(report.Changes, ImportChange{Action: ImportSkip, Record: *record})
+			}
+			report.Skipped++
+			return nil
+		}
+	}
+
+	switch record.Type {
+	case ExportItem:
+		action, err := c.importItem(record, opts)
+		if err != nil {
+			return err
+		}
+		if opts.DryRun {
+			report.Changes = append(report.Changes, ImportChange{Action: action, Record: *record})
+		}
+		*skipping = nil
+		switch action {
+		case ImportSkip, ImportConflict:
+			*skipping = &path
+			report.Skipped++
+		case ImportCreate, ImportUpdate:
+			report.Items++
+		}
+
+	case ExportEvent:
+		if opts.DryRun {
+			report.Changes = append(report.Changes, ImportChange{Action: ImportCreate, Record: *record})
+		} else {
+			_, err := c.PutEventWithTimeRaw(path.Collection, path.Key, record.Kind,
+				int64(record.Timestamp), bytes.NewReader(record.Value))
+			if err != nil {
+				return err
+			}
+		}
+		report.Events++
+
+	case ExportRelation:
+		if record.To == nil {
+			return fmt.Errorf("Relation from %s/%s is missing its destination", path.Collection, path.Key)
+		}
+		if opts.DryRun {
+			report.Changes = append(report.Changes, ImportChange{Action: ImportCreate, Record: *record})
+		} else {
+			err := c.PutRelation(path.Collection, path.Key, record.Kind, record.To.Collection, record.To.Key)
+			if err != nil {
+				return err
+			}
+		}
+		report.Relations++
+
+	default:
+		report.Ignored++
+	}
+
+	return nil
+}
+
+// Imports an item according to the import mode, returning the action taken.
+// In a dry run the current value is fetched to decide what would happen.
+// Overwrites always write, even when the value is unchanged, so they are
+// reported as updates.
+func (c *Client) importItem(record *ExportRecord, opts *ImportOptions) (string, error) {
+	path := record.Path
+
+	if opts.DryRun {
+		_, err := c.Get(path.Collection, path.Key)
+		if hasStatus(err, 404) {
+			return ImportCreate, nil
+		} else if err != nil {
+			return "", err
+		}
+
+		switch opts.Mode {
+		case ImportSkipExisting:
+			return ImportSkip, nil
+		case ImportFailOnConflict:
+			return ImportConflict, nil
+		}
+		return ImportUpdate, nil
+	}
+
+	if opts.Mode == ImportOverwrite {
+		_, err := c.PutRaw(path.Collection, path.Key, bytes.NewReader(record.Value))
+		return ImportUpdate, err
+	}
+
+	_, err := c.PutIfAbsentRaw(path.Collection, path.Key, bytes.NewReader(record.Value))
+	if hasStatus(err, 412) {
+		if opts.Mode == ImportFailOnConflict {
+			return "", &ImportConflictError{Path: path}
+		}
+		return ImportSkip, nil
+	}
+	return ImportCreate, err
+}
+
+// Convert the error to a meaningful string.
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("Item already exists: %s/%s", e.Path.Collection, e.Path.Key)
+}
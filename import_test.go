@@ -0,0 +1,131 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestImportRemapRecord(t *testing.T) {
+	record := ExportRecord{
+		Type: ExportRelation,
+		Path: Path{Collection: "users", Key: "a"},
+		To:   &Path{Collection: "users", Key: "b"},
+	}
+	other := ExportRecord{
+		Type: ExportRelation,
+		Path: Path{Collection: "users", Key: "a"},
+		To:   &Path{Collection: "groups", Key: "c"},
+	}
+
+	remapRecord(&record, "restored")
+	remapRecord(&other, "restored")
+
+	if record.Path.Collection != "restored" || record.To.Collection != "restored" {
+		t.Errorf("Relation within the collection was not moved: %+v -> %+v", record.Path, record.To)
+	}
+	if other.Path.Collection != "restored" || other.To.Collection != "groups" {
+		t.Errorf("Relation to another collection was moved: %+v -> %+v", other.Path, other.To)
+	}
+}
+
+// An export of one item with an event and a relation.
+const importStream = `{"type":"item","path":{"collection":"users","key":"mary"},"value":{"name":"Mary"}}
+{"type":"event","path":{"collection":"users","key":"mary"},"kind":"login","timestamp":1398286518286,"value":{}}
+{"type":"relation","path":{"collection":"users","key":"mary"},"kind":"friend","to":{"collection":"users","key":"bob"}}
+`
+
+// Returns a client for a server where users/mary already holds the imported
+// value, along with a log of the writes the server received.
+func newImportClient() (*Client, *httptest.Server, *[]string) {
+	var lock sync.Mutex
+	writes := []string{}
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET":
+			w.Write([]byte(`{"name":"Mary"}`))
+		case r.Header.Get("If-None-Match") == `"*"`:
+			w.WriteHeader(412)
+			w.Write([]byte(`{"message": "exists"}`))
+		default:
+			lock.Lock()
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			lock.Unlock()
+			switch {
+			case strings.Contains(r.URL.Path, "/relation/"):
+				w.WriteHeader(204)
+			case strings.Contains(r.URL.Path, "/events/"):
+				w.Header().Set("Location", r.URL.Path+"/1398286518286/1")
+				w.WriteHeader(204)
+			default:
+				writeCreated(w, r, "abc")
+			}
+		}
+	}))
+	return c, server, &writes
+}
+
+func TestImportSkipExistingSkipsDependents(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		c, server, writes := newImportClient()
+		opts := &ImportOptions{Mode: ImportSkipExisting, DryRun: dryRun}
+		report, err := c.Import(strings.NewReader(importStream), opts)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Skipped != 3 || report.Items != 0 || report.Events != 0 || report.Relations != 0 {
+			t.Errorf("DryRun %v: report = %+v, expected everything skipped", dryRun, report)
+		}
+		if len(*writes) != 0 {
+			t.Errorf("DryRun %v: wrote %v", dryRun, *writes)
+		}
+		for _, change := range report.Changes {
+			if change.Action != ImportSkip {
+				t.Errorf("DryRun %v: %s record reported as %s", dryRun, change.Record.Type, change.Action)
+			}
+		}
+	}
+}
+
+func TestImportDryRunMatchesOverwrite(t *testing.T) {
+	c, server, writes := newImportClient()
+	defer server.Close()
+
+	dry, err := c.Import(strings.NewReader(importStream), &ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Changes) == 0 || dry.Changes[0].Action != ImportUpdate {
+		t.Errorf("Dry run changes = %+v, expected an update of the unchanged item", dry.Changes)
+	}
+
+	real, err := c.Import(strings.NewReader(importStream), &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if real.Items != dry.Items || real.Skipped != dry.Skipped ||
+		real.Events != dry.Events || real.Relations != dry.Relations {
+		t.Errorf("Dry run predicted %+v, real run reported %+v", dry, real)
+	}
+	if len(*writes) != 3 {
+		t.Errorf("Wrote %v, expected the item, event and relation", *writes)
+	}
+}
@@ -20,9 +20,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Holds results returned from a KV list query.
@@ -36,6 +38,26 @@ type KVResults struct {
 type KVResult struct {
 	Path     Path            `json:"path"`
 	RawValue json.RawMessage `json:"value"`
+
+	// The time this ref was created, in milliseconds since the epoch. List
+	// results carry this in the body, for GetPath it is derived from the
+	// Last-Modified header and so only has second precision.
+	RefTime uint64 `json:"reftime,omitempty"`
+
+	// The remaining fields are populated from the response headers of
+	// GetPath and are left empty in list results.
+
+	// The ETag header exactly as it was returned by the server.
+	ETag string `json:"-"`
+
+	// The length of the value in bytes, or -1 if the server did not say.
+	ContentLength int64 `json:"-"`
+
+	// All of the headers returned with the value.
+	Header http.Header `json:"-"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
 }
 
 // Represents a single operation to be performed when patching an existing
@@ -62,7 +84,8 @@ func (c *Client) Get(collection, key string) (*KVResult, error) {
 	return c.GetPath(&Path{Collection: collection, Key: key})
 }
 
-// Get the value at a path.
+// Get the value at a path. If the path has no ref then the returned result
+// will hold the ref of the current value, the given path is not modified.
 func (c *Client) GetPath(path *Path) (*KVResult, error) {
 	resp, err := c.doRequest("GET", path.trailingGetURI(), nil, nil)
 	if err != nil {
@@ -76,31 +99,44 @@ func (c *Client) GetPath(path *Path) (*KVResult, error) {
 		return nil, newError(resp)
 	}
 
-	// Decode the body into a JSON object.
-	// TODO: Check for a content-length header so we can pre-allocate buffer
-	// space.
+	// Read the body, pre-allocating the buffer if we know how big it will be.
 	buf := bytes.NewBuffer(nil)
+	if resp.ContentLength > 0 {
+		buf.Grow(int(resp.ContentLength))
+	}
 	if _, err := buf.ReadFrom(resp.Body); err != nil {
 		return nil, err
 	}
 
-	if path.Ref == "" {
-		parts := strings.SplitAfter(resp.Header.Get("Content-Location"), "/")
-		if len(parts) >= 6 {
-			path.Ref = parts[5]
+	result := &KVResult{
+		Path:          *path,
+		RawValue:      buf.Bytes(),
+		ETag:          resp.Header.Get("ETag"),
+		ContentLength: resp.ContentLength,
+		Header:        resp.Header,
+		codec:         c.codec(),
+	}
+
+	if lastModified := result.LastModified(); !lastModified.IsZero() {
+		result.RefTime = uint64(timeToMillis(lastModified))
+	}
+
+	// The Content-Location header is the authoritative source of the ref,
+	// but the ETag holds it as well so fall back to that.
+	if result.Path.Ref == "" {
+		ref, err := refFromLocation(resp.Header.Get("Content-Location"))
+		if err != nil {
+			ref = refFromETag(result.ETag)
 		}
+		result.Path.Ref = ref
 	}
 
-	return &KVResult{Path: *path, RawValue: buf.Bytes()}, nil
+	return result, nil
 }
 
 // Store a value to a collection-key pair.
 func (c *Client) Put(collection string, key string, value interface{}) (*Path, error) {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
-
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutRaw(collection, key, reader)
+	return c.PutRaw(collection, key, c.encode(value))
 }
 
 // Store a value to a collection-key pair.
@@ -110,11 +146,7 @@ func (c *Client) PutRaw(collection string, key string, value io.Reader) (*Path,
 
 // Store a value to a collection-key pair if the path's ref value is the latest.
 func (c *Client) PutIfUnmodified(path *Path, value interface{}) (*Path, error) {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
-
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutIfUnmodifiedRaw(path, reader)
+	return c.PutIfUnmodifiedRaw(path, c.encode(value))
 }
 
 // Store a value to a collection-key pair if the path's ref value is the latest.
@@ -128,11 +160,7 @@ func (c *Client) PutIfUnmodifiedRaw(path *Path, value io.Reader) (*Path, error)
 
 // Store a value to a collection-key pair if it doesn't already hold a value.
 func (c *Client) PutIfAbsent(collection, key string, value interface{}) (*Path, error) {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
-
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PutIfAbsentRaw(collection, key, reader)
+	return c.PutIfAbsentRaw(collection, key, c.encode(value))
 }
 
 // Store a value to a collection-key pair if it doesn't already hold a value.
@@ -162,12 +190,9 @@ func (c *Client) doPut(path *Path, headers map[string]string, value io.Reader) (
 	io.Copy(ioutil.Discard, resp.Body)
 
 	// Parse the ref of the returned object.
-	ref := ""
-	parts := strings.SplitAfter(resp.Header.Get("Location"), "/")
-	if len(parts) >= 6 {
-		ref = parts[5]
-	} else {
-		return nil, fmt.Errorf("Missing ref component: %s", resp.Header.Get("Location"))
+	ref, err := refFromLocation(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
 	}
 
 	// Return the results.
@@ -180,11 +205,7 @@ func (c *Client) doPut(path *Path, headers map[string]string, value io.Reader) (
 
 // Send a set of patch operations for a collection-key pair.
 func (c *Client) Patch(collection string, key string, value PatchSet) (*Path, error) {
-	reader, writer := io.Pipe()
-	encoder := json.NewEncoder(writer)
-
-	go func() { writer.CloseWithError(encoder.Encode(value)) }()
-	return c.PatchRaw(collection, key, reader)
+	return c.PatchRaw(collection, key, c.encode(value))
 }
 
 // Send a set of patch operations for a collection-key pair.
@@ -213,12 +234,9 @@ func (c *Client) doPatch(path *Path, headers map[string]string, value io.Reader)
 	io.Copy(ioutil.Discard, resp.Body)
 
 	// Parse the ref of the returned object.
-	ref := ""
-	parts := strings.SplitAfter(resp.Header.Get("Location"), "/")
-	if len(parts) >= 6 {
-		ref = parts[5]
-	} else {
-		return nil, fmt.Errorf("Missing ref component: %s", resp.Header.Get("Location"))
+	ref, err := refFromLocation(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
 	}
 
 	// Return the results.
@@ -327,7 +345,7 @@ func (c *Client) ListRange(collection, start, end string, limit int) (*KVResults
 
 // Get the page of key/value list results that follow that provided set.
 func (c *Client) ListGetNext(results *KVResults) (*KVResults, error) {
-	return c.doList(results.Next[4:])
+	return c.doList(trailingFromLink(results.Next))
 }
 
 // Execute a key/value list operation.
@@ -350,6 +368,9 @@ func (c *Client) doList(trailingUri string) (*KVResults, error) {
 	if err := decoder.Decode(result); err != nil {
 		return result, err
 	}
+	for i := range result.Results {
+		result.Results[i].codec = c.codec()
+	}
 
 	return result, nil
 }
@@ -359,9 +380,46 @@ func (r *KVResults) HasNext() bool {
 	return r.Next != ""
 }
 
+// The parsed Last-Modified header of a GetPath result, or the zero time if it
+// was missing. This is a method rather than a field so that KVResult only
+// holds plain values.
+func (r *KVResult) LastModified() time.Time {
+	lastModified, err := http.ParseTime(r.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return lastModified
+}
+
 // Marshall the value of a KVResult into the provided object.
 func (r *KVResult) Value(value interface{}) error {
-	return json.Unmarshal(r.RawValue, value)
+	return decodeValue(r.codec, r.RawValue, value)
+}
+
+// Extracts the ref from a Location or Content-Location header. These take
+// the form "/v0/collection/key/refs/ref" but may be absolute URLs, and the
+// key may itself contain escaped slashes, so the ref is taken from the
+// last "refs" segment rather than by position.
+func refFromLocation(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if parts[i] == "refs" && parts[i+1] != "" {
+			return parts[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("Missing ref component: %s", location)
+}
+
+// Extracts the ref from an ETag header, which is the quoted ref with an
+// optional weak prefix and encoding suffix, for example: W/"abc123-gzip".
+func refFromETag(etag string) string {
+	etag = strings.TrimPrefix(etag, "W/")
+	etag = strings.Trim(etag, `"`)
+	return strings.TrimSuffix(etag, "-gzip")
 }
 
 // Returns the trailing URI part for a GET request.
@@ -20,7 +20,8 @@ import (
 )
 
 func TestKVHasNext(t *testing.T) {
-	f := func(results *KVResults) bool {
+	f := func(next string) bool {
+		results := &KVResults{Next: next}
 		return !(results.Next == "" && results.HasNext())
 	}
 
@@ -51,3 +52,55 @@ func TestKVTrailingPutUri(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRefFromLocation(t *testing.T) {
+	tests := map[string]string{
+		"/v0/collection/key/refs/0123456789abcdef":              "0123456789abcdef",
+		"https://api.orchestrate.io/v0/collection/key/refs/abc": "abc",
+		"/v0/collection/some%2Fkey/refs/abc":                    "abc",
+		"/v0/refs/refs/refs/abc":                                "abc",
+		"/v0/collection/key/refs/abc/":                          "abc",
+	}
+	for location, expected := range tests {
+		ref, err := refFromLocation(location)
+		if err != nil {
+			t.Errorf("refFromLocation(%q): %s", location, err)
+		} else if ref != expected {
+			t.Errorf("refFromLocation(%q) = %q, expected %q", location, ref, expected)
+		}
+	}
+
+	for _, location := range []string{"", "/v0/collection/key", "/v0/collection/refs"} {
+		if ref, err := refFromLocation(location); err == nil {
+			t.Errorf("refFromLocation(%q) = %q, expected an error", location, ref)
+		}
+	}
+}
+
+func TestRefFromETag(t *testing.T) {
+	tests := map[string]string{
+		`"abc"`:        "abc",
+		`"abc-gzip"`:   "abc",
+		`W/"abc-gzip"`: "abc",
+		"":             "",
+	}
+	for etag, expected := range tests {
+		if ref := refFromETag(etag); ref != expected {
+			t.Errorf("refFromETag(%q) = %q, expected %q", etag, ref, expected)
+		}
+	}
+}
+
+func TestTrailingFromLink(t *testing.T) {
+	tests := map[string]string{
+		"/v0/collection?limit=10&afterKey=a":                          "collection?limit=10&afterKey=a",
+		"/v0/collection/key/refs/?limit=10&offset=10":                 "collection/key/refs/?limit=10&offset=10",
+		"https://api.orchestrate.io/v0/collection?limit=10&offset=10": "collection?limit=10&offset=10",
+		"v0/collection?limit=10":                                      "collection?limit=10",
+	}
+	for link, expected := range tests {
+		if trailing := trailingFromLink(link); trailing != expected {
+			t.Errorf("trailingFromLink(%q) = %q, expected %q", link, trailing, expected)
+		}
+	}
+}
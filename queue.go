@@ -0,0 +1,467 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Returned when acknowledging a job whose lease expired and was claimed by
+// another worker.
+var ErrLeaseLost = errors.New("gorc: job lease was lost")
+
+// Claims look for jobs a span of time at a time, starting with this many
+// milliseconds, and fetch up to queueScanPage jobs per request.
+const (
+	queueScanSpan = 60 * 1000
+	queueScanPage = 100
+)
+
+// The states a job lease can be in.
+const (
+	leaseClaimed = "claimed"
+	leaseDone    = "done"
+	leaseDead    = "dead"
+)
+
+// A durable work queue built on events. Jobs are events on a collection-key
+// pair and each job has a lease item recording which worker holds it, how
+// many attempts have been made and whether it has completed.
+//
+// A claimed job that is not acknowledged within the visibility timeout
+// becomes available to other workers again. Jobs that fail MaxAttempts times
+// are copied to the dead letter event type.
+type Queue struct {
+	// Jobs are events of type Kind on the Collection/Key pair.
+	Collection string
+	Key        string
+	Kind       string
+
+	// The collection holding job leases.
+	LeaseCollection string
+
+	// How long a claimed job is hidden from other workers.
+	VisibilityTimeout time.Duration
+
+	// The number of attempts before a job is dead lettered.
+	MaxAttempts int
+
+	// The event type failed jobs are copied to on Collection/Key.
+	DeadLetterKind string
+
+	// Called with any error encountered by a consumer while claiming or
+	// acknowledging jobs. If nil those errors are dropped.
+	OnError func(err error)
+
+	client *Client
+
+	// Every job up to and including this one is known to be finished. This
+	// is loaded from the lease collection by the first claim.
+	lock      sync.Mutex
+	low       *EventBound
+	lowLoaded bool
+
+	// The width of the last span of time found to hold jobs, in
+	// milliseconds.
+	span int64
+}
+
+// A job claimed from a queue.
+type Job struct {
+	// The event holding the job.
+	Event Event
+
+	// The attempt number, starting at one.
+	Attempt int
+
+	queue *Queue
+	lease Path
+}
+
+// Runs a handler over jobs from a queue with a pool of workers.
+type QueueConsumer struct {
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// The value stored for each job lease.
+type jobLease struct {
+	State    string `json:"state"`
+	Attempts int    `json:"attempts"`
+	Expires  int64  `json:"expires"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Returns a queue of jobs stored as events on a collection-key pair with
+// defaults for everything else. The fields may be changed before use.
+func (c *Client) NewQueue(collection, key string) *Queue {
+	return &Queue{
+		Collection:        collection,
+		Key:               key,
+		Kind:              "job",
+		LeaseCollection:   collection + "-leases",
+		VisibilityTimeout: 30 * time.Second,
+		MaxAttempts:       5,
+		DeadLetterKind:    "dead-job",
+		client:            c,
+	}
+}
+
+// Add a job to the queue.
+func (q *Queue) Enqueue(value interface{}) (*EventPath, error) {
+	return q.client.PostEvent(q.Collection, q.Key, q.Kind, value)
+}
+
+// Claim the oldest available job. This returns nil if no job is available.
+//
+// Claims never read the whole queue. They start after the last finished
+// job, which is stored alongside the leases so that it survives restarts,
+// and look at a span of time at a time. A span is narrowed while it holds
+// more jobs than one request returns and widened while it holds none.
+func (q *Queue) Claim() (*Job, error) {
+	low, err := q.watermark()
+	if err != nil {
+		return nil, err
+	}
+
+	// The newest job bounds the scan.
+	newest, err := q.client.GetEventsRange(q.Collection, q.Key, q.Kind, &EventRange{After: low, PageSize: 1})
+	if err != nil || len(newest.Results) == 0 {
+		return nil, err
+	}
+	last := int64(newest.Results[0].Timestamp)
+
+	// Jobs at the front of the queue that are finished let the next scan
+	// start later.
+	finished := low
+	finishedPrefix := true
+	defer func() { q.advance(finished) }()
+
+	q.lock.Lock()
+	from, span := low, q.span
+	q.lock.Unlock()
+	if span <= 0 {
+		span = queueScanSpan
+	}
+
+	for {
+		end := &EventBound{Timestamp: span}
+		if from != nil {
+			end.Timestamp += from.Timestamp
+		}
+
+		events, ok, err := q.scan(from, end, span > 1)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			span /= 2
+			continue
+		}
+
+		if len(events) > 0 {
+			q.lock.Lock()
+			q.span = span
+			q.lock.Unlock()
+		}
+
+		for i := range events {
+			event := &events[i]
+			job, done, err := q.tryClaim(event)
+			if err != nil {
+				return nil, err
+			} else if job != nil {
+				return job, nil
+			}
+
+			if finishedPrefix = finishedPrefix && done; finishedPrefix {
+				finished = &EventBound{Timestamp: int64(event.Timestamp), Ordinal: event.Ordinal}
+			}
+		}
+
+		if end.Timestamp >= last {
+			return nil, nil
+		}
+
+		// New jobs are always later than the newest one, so nothing more
+		// can appear in a span that is entirely before it.
+		if finishedPrefix {
+			finished = end
+		}
+		if len(events) == 0 {
+			span *= 2
+		}
+		from = end
+	}
+}
+
+// Returns the jobs after from and up to end, oldest first. If there are
+// more than one request returns and narrow is set this returns false so the
+// span can be narrowed instead.
+func (q *Queue) scan(from, end *EventBound, narrow bool) ([]Event, bool, error) {
+	query := &EventRange{After: from, End: end, PageSize: queueScanPage}
+	page, err := q.client.GetEventsRange(q.Collection, q.Key, q.Kind, query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(page.Results) >= queueScanPage {
+		if narrow {
+			return nil, false, nil
+		}
+
+		// Too many jobs share a millisecond to narrow any further.
+		var events []Event
+		query.Ascending = true
+		iter := q.client.IterateEvents(q.Collection, q.Key, q.Kind, query)
+		for iter.Next() {
+			events = append(events, *iter.Event())
+		}
+		return events, true, iter.Err()
+	}
+
+	events := page.Results
+	for l, r := 0, len(events)-1; l < r; l, r = l+1, r-1 {
+		events[l], events[r] = events[r], events[l]
+	}
+	return events, true, nil
+}
+
+// Returns the point up to which every job is known to be finished, loading
+// it from the lease collection the first time.
+func (q *Queue) watermark() (*EventBound, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.lowLoaded {
+		return q.low, nil
+	}
+
+	result, err := q.client.Get(q.LeaseCollection, q.watermarkKey())
+	if hasStatus(err, 404) {
+		q.low = nil
+	} else if err != nil {
+		return nil, err
+	} else {
+		low := new(EventBound)
+		if err := q.client.Decode(result.RawValue, low); err != nil {
+			return nil, err
+		}
+		q.low = low
+	}
+
+	q.lowLoaded = true
+	return q.low, nil
+}
+
+// Records that every job up to and including finished is done, if that is
+// further than already known. The stored position only saves later claims
+// work, so failing to write it is not an error.
+func (q *Queue) advance(finished *EventBound) {
+	q.lock.Lock()
+	if finished == nil || (q.low != nil && !boundAfter(finished, q.low)) {
+		q.lock.Unlock()
+		return
+	}
+	q.low = finished
+	q.lock.Unlock()
+
+	q.client.Put(q.LeaseCollection, q.watermarkKey(), finished)
+}
+
+// Reports whether bound a is later than bound b. A bound without an ordinal
+// covers its entire millisecond, so it is later than any event within it.
+func boundAfter(a, b *EventBound) bool {
+	switch {
+	case a.Timestamp != b.Timestamp:
+		return a.Timestamp > b.Timestamp
+	case b.Ordinal == 0:
+		return false
+	}
+	return a.Ordinal == 0 || a.Ordinal > b.Ordinal
+}
+
+// Attempts to claim the job held in an event. This returns the job if it was
+// claimed, or whether the job is finished if not.
+func (q *Queue) tryClaim(event *Event) (*Job, bool, error) {
+	c := q.client
+	leaseKey := q.leaseKey(event)
+	now := time.Now()
+
+	result, err := c.Get(q.LeaseCollection, leaseKey)
+	if hasStatus(err, 404) {
+		lease := &jobLease{
+			State:    leaseClaimed,
+			Attempts: 1,
+			Expires:  timeToMillis(now.Add(q.VisibilityTimeout)),
+		}
+		path, err := c.PutIfAbsent(q.LeaseCollection, leaseKey, lease)
+		if hasStatus(err, 412) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		return &Job{Event: *event, Attempt: 1, queue: q, lease: *path}, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	lease := new(jobLease)
+	if err := c.Decode(result.RawValue, lease); err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case lease.State == leaseDone || lease.State == leaseDead:
+		return nil, true, nil
+	case lease.Expires > timeToMillis(now):
+		return nil, false, nil
+	case lease.Attempts >= q.MaxAttempts:
+		err := q.deadLetter(event, &result.Path, lease)
+		return nil, err == nil, err
+	}
+
+	lease.Attempts++
+	lease.Expires = timeToMillis(now.Add(q.VisibilityTimeout))
+	path, err := c.PutIfUnmodified(&result.Path, lease)
+	if hasStatus(err, 412) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return &Job{Event: *event, Attempt: lease.Attempts, queue: q, lease: *path}, false, nil
+}
+
+// Copies a job to the dead letter event type and marks its lease dead. The
+// copy is made first so a job is never lost, though it may be copied twice
+// if two workers race.
+func (q *Queue) deadLetter(event *Event, leasePath *Path, lease *jobLease) error {
+	_, err := q.client.PostEvent(q.Collection, q.Key, q.DeadLetterKind, event.RawValue)
+	if err != nil {
+		return err
+	}
+
+	lease.State = leaseDead
+	_, err = q.client.PutIfUnmodified(leasePath, lease)
+	if hasStatus(err, 412) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// Start workers that claim jobs and pass them to handler. A job is
+// acknowledged if the handler returns nil and failed otherwise. When no job
+// is available each worker waits pollInterval before trying again.
+func (q *Queue) Consume(workers int, pollInterval time.Duration, handler func(job *Job) error) *QueueConsumer {
+	if workers <= 0 {
+		workers = q.client.concurrency()
+	}
+
+	consumer := &QueueConsumer{stop: make(chan struct{})}
+	consumer.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work(consumer, pollInterval, handler)
+	}
+	return consumer
+}
+
+// Claims and handles jobs until the consumer is stopped.
+func (q *Queue) work(consumer *QueueConsumer, pollInterval time.Duration, handler func(job *Job) error) {
+	defer consumer.wg.Done()
+
+	for {
+		select {
+		case <-consumer.stop:
+			return
+		default:
+		}
+
+		job, err := q.Claim()
+		if err == nil && job != nil {
+			if herr := handler(job); herr != nil {
+				err = job.Fail(herr)
+			} else {
+				err = job.Ack()
+			}
+		}
+		if err != nil && q.OnError != nil {
+			q.OnError(err)
+		}
+
+		// Only wait if there was nothing to do.
+		if err != nil || job == nil {
+			select {
+			case <-consumer.stop:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+}
+
+// Returns the key of the lease for a job.
+func (q *Queue) leaseKey(event *Event) string {
+	return q.Key + "_" + q.Kind + "_" +
+		strconv.FormatUint(event.Timestamp, 10) + "_" +
+		strconv.FormatUint(event.Ordinal, 10)
+}
+
+// Returns the key the finished position of the queue is stored under. This
+// can not clash with a lease key as those end in a number.
+func (q *Queue) watermarkKey() string {
+	return q.Key + "_" + q.Kind + "_finished"
+}
+
+// Stop the workers, waiting for any jobs being handled to finish.
+func (c *QueueConsumer) Stop() {
+	c.once.Do(func() { close(c.stop) })
+	c.wg.Wait()
+}
+
+// Marshall the value of the job into the provided object using the queue's
+// client codec.
+func (j *Job) Value(value interface{}) error {
+	return j.queue.client.Decode(j.Event.RawValue, value)
+}
+
+// Mark the job as completed so that it is never handed out again.
+func (j *Job) Ack() error {
+	return j.update(&jobLease{State: leaseDone, Attempts: j.Attempt})
+}
+
+// Mark an attempt at the job as failed. The job becomes available again
+// straight away, or is dead lettered if it has no attempts left.
+func (j *Job) Fail(reason error) error {
+	lease := &jobLease{State: leaseClaimed, Attempts: j.Attempt, Error: reason.Error()}
+	if j.Attempt >= j.queue.MaxAttempts {
+		return j.queue.deadLetter(&j.Event, &j.lease, lease)
+	}
+	return j.update(lease)
+}
+
+// Writes the job's lease if it is still held.
+func (j *Job) update(lease *jobLease) error {
+	path, err := j.queue.client.PutIfUnmodified(&j.lease, lease)
+	if hasStatus(err, 412) {
+		return ErrLeaseLost
+	} else if err != nil {
+		return err
+	}
+	j.lease = *path
+	return nil
+}
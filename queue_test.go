@@ -0,0 +1,246 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Claims a job, failing if none is available.
+func claimJob(t *testing.T, q *Queue) *Job {
+	job, err := q.Claim()
+	if err != nil {
+		t.Fatal(err)
+	} else if job == nil {
+		t.Fatal("Claim() found no job")
+	}
+	return job
+}
+
+// Checks that no job is available.
+func claimNothing(t *testing.T, q *Queue) {
+	if job, err := q.Claim(); err != nil {
+		t.Fatal(err)
+	} else if job != nil {
+		t.Fatalf("Claim() = attempt %d of %s", job.Attempt, job.Event.RawValue)
+	}
+}
+
+func TestQueueLeaseExpiry(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	q := c.NewQueue("work", "jobs")
+	q.VisibilityTimeout = 50 * time.Millisecond
+	if _, err := q.Enqueue("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := claimJob(t, q)
+	if first.Attempt != 1 {
+		t.Errorf("First claim is attempt %d", first.Attempt)
+	}
+	claimNothing(t, q)
+
+	// Once the lease expires another worker takes the job over, and the
+	// first worker can no longer acknowledge it.
+	time.Sleep(60 * time.Millisecond)
+	second := claimJob(t, q)
+	if second.Attempt != 2 || second.Event.Ordinal != first.Event.Ordinal {
+		t.Errorf("Second claim is attempt %d of ordinal %d", second.Attempt, second.Event.Ordinal)
+	}
+	if err := first.Ack(); err != ErrLeaseLost {
+		t.Errorf("Ack() of an expired lease = %v", err)
+	}
+	if err := second.Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	claimNothing(t, q)
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	q := c.NewQueue("work", "jobs")
+	q.MaxAttempts = 2
+	q.Enqueue("a")
+	q.Enqueue("b")
+
+	job := claimJob(t, q)
+	var value string
+	if job.Value(&value); value != "a" {
+		t.Fatalf("Claimed %q, expected the oldest job", value)
+	}
+	if err := job.Fail(errors.New("broken")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed job is available again straight away.
+	job = claimJob(t, q)
+	if job.Value(&value); value != "a" || job.Attempt != 2 {
+		t.Fatalf("Claimed attempt %d of %q, expected a retry of a", job.Attempt, value)
+	}
+	if err := job.Fail(errors.New("broken")); err != nil {
+		t.Fatal(err)
+	}
+
+	dead := fake.Events("work", "jobs", "dead-job")
+	if len(dead) != 1 || string(dead[0].RawValue) != `"a"`+"\n" {
+		t.Errorf("Dead letters = %+v", dead)
+	}
+
+	// The dead job is never handed out again.
+	job = claimJob(t, q)
+	if job.Value(&value); value != "b" {
+		t.Errorf("Claimed %q after dead lettering, expected b", value)
+	}
+	job.Ack()
+	claimNothing(t, q)
+}
+
+func TestQueueConsume(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	q := c.NewQueue("work", "jobs")
+	expected := []string{"a", "b", "c", "d", "e", "f"}
+	for _, value := range expected {
+		q.Enqueue(value)
+	}
+
+	var lock sync.Mutex
+	var handled []string
+	done := make(chan bool)
+	q.OnError = func(err error) { t.Error(err) }
+	consumer := q.Consume(3, 5*time.Millisecond, func(job *Job) error {
+		var value string
+		job.Value(&value)
+
+		lock.Lock()
+		defer lock.Unlock()
+		handled = append(handled, value)
+		if len(handled) == len(expected) {
+			close(done)
+		}
+		return nil
+	})
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for jobs")
+	}
+
+	// Give the workers a chance to hand out anything twice.
+	time.Sleep(30 * time.Millisecond)
+	consumer.Stop()
+
+	sort.Strings(handled)
+	if strings.Join(handled, "") != strings.Join(expected, "") {
+		t.Errorf("Handled %v, expected each job once", handled)
+	}
+}
+
+// Counts the requests made to a handler by method and collection.
+type countingHandler struct {
+	handler http.Handler
+	lock    sync.Mutex
+	counts  map[string]int
+}
+
+func (c *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	collection := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v0/"), "/", 2)[0]
+	c.lock.Lock()
+	c.counts[r.Method+" "+collection]++
+	c.lock.Unlock()
+	c.handler.ServeHTTP(w, r)
+}
+
+// Returns the number of requests since the last call.
+func (c *countingHandler) take(method, collection string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	count := c.counts[method+" "+collection]
+	delete(c.counts, method+" "+collection)
+	return count
+}
+
+func TestQueueResumesAfterFinishedJobs(t *testing.T) {
+	counter := &countingHandler{handler: newFakeOrchestrate(), counts: map[string]int{}}
+	c, server := newTestClient(counter)
+	defer server.Close()
+
+	q := c.NewQueue("work", "jobs")
+	for i := 0; i < 20; i++ {
+		q.Enqueue(i)
+	}
+	for i := 0; i < 19; i++ {
+		claimJob(t, q).Ack()
+	}
+
+	// A new process picks up after the finished jobs without looking at
+	// their leases again.
+	counter.take("GET", "work-leases")
+	q = c.NewQueue("work", "jobs")
+	job := claimJob(t, q)
+	var value int
+	if job.Value(&value); value != 19 {
+		t.Errorf("Claimed %d, expected the last job", value)
+	}
+	// The position only covers jobs finished before the last claim, so the
+	// lease of the job acknowledged after it is read too.
+	if gets := counter.take("GET", "work-leases"); gets != 3 {
+		t.Errorf("Claim() made %d lease reads, expected the position and two leases", gets)
+	}
+}
+
+func TestQueueClaimsOldestFirst(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	// More jobs than one request returns share a millisecond, and there
+	// is a long gap before the last one.
+	start := time.Date(2014, 4, 23, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < queueScanPage+10; i++ {
+		c.PutEventWithTime("work", "jobs", "job", timeToMillis(start), i)
+	}
+	c.PutEventWithTime("work", "jobs", "job", timeToMillis(start.Add(24*time.Hour)), "last")
+
+	q := c.NewQueue("work", "jobs")
+	for i := 0; i < queueScanPage+10; i++ {
+		job := claimJob(t, q)
+		var value int
+		if job.Value(&value); value != i {
+			t.Fatalf("Claimed %d, expected %d", value, i)
+		}
+		job.Ack()
+	}
+
+	var value string
+	if claimJob(t, q).Value(&value); value != "last" {
+		t.Errorf("Claimed %q, expected the last job", value)
+	}
+	claimNothing(t, q)
+}
@@ -3,11 +3,18 @@
 package gorc
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
 	"strconv"
+	"time"
 )
 
+// Returned by GetAsOf when an item had never been written at the given time.
+var ErrNoValueAsOf = errors.New("gorc: no value existed at the requested time")
+
 // Holds results returned from a ref list.
 type RefResults struct {
 	Count   uint64      `json:"count"`
@@ -20,8 +27,38 @@ type RefResult struct {
 	Path     Path            `json:"path"`
 	RawValue json.RawMessage `json:"value,omitempty"`
 	RefTime  uint64          `json:"reftime"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
+}
+
+// Iterates over the full ref history of an item, fetching pages as needed.
+//
+//	iter := c.IterateRefs("collection", "key", true, false)
+//	for iter.Next() {
+//		ref := iter.Result()
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type RefIterator struct {
+	client      *Client
+	collection  string
+	key         string
+	values      bool
+	oldestFirst bool
+	offset      int
+
+	page    *RefResults
+	index   int
+	result  *RefResult
+	fetched bool
+	err     error
 }
 
+// The number of refs fetched per page by a RefIterator.
+const refPageSize = 100
+
 // Get a collection-key pair's value at a specific ref.
 func (c *Client) GetRef(collection, key, ref string) (*KVResult, error) {
 	return c.GetPath(&Path{Collection: collection, Key: key, Ref: ref})
@@ -56,7 +93,126 @@ func (c *Client) ListRefsFromOffset(collection, key string, limit int, values bo
 
 // Get the page of ref list results that follow the provided set.
 func (c *Client) ListRefsGetNext(results *RefResults) (*RefResults, error) {
-	return c.doListRefs(results.Next[4:])
+	return c.doListRefs(trailingFromLink(results.Next))
+}
+
+// Restore an item to the value it held at a previous ref. If that ref is a
+// deletion then the item is deleted. The write is conditional on the current
+// ref so a concurrent change fails the restore rather than being lost.
+func (c *Client) Restore(collection, key, ref string) (*Path, error) {
+	var value []byte
+	historical, err := c.GetRef(collection, key, ref)
+	if hasStatus(err, 404) {
+		// Deletions have no value to get, so only then is the history
+		// searched to tell a deletion from a ref that does not exist.
+		deletion, err := c.findRef(collection, key, ref)
+		if err != nil {
+			return nil, err
+		} else if !deletion.IsDeleted() {
+			return nil, fmt.Errorf("Ref has no value: %s/%s/refs/%s", collection, key, ref)
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		value = historical.RawValue
+	}
+
+	current, err := c.Get(collection, key)
+	exists := true
+	if hasStatus(err, 404) {
+		exists = false
+	} else if err != nil {
+		return nil, err
+	}
+
+	if value == nil {
+		if exists {
+			if err := c.DeleteIfUnmodified(&current.Path); err != nil {
+				return nil, err
+			}
+		}
+		return &Path{Collection: collection, Key: key, Tombstone: true}, nil
+	}
+
+	if !exists {
+		return c.PutIfAbsentRaw(collection, key, bytes.NewReader(value))
+	}
+	if current.Path.Ref == ref {
+		return &current.Path, nil
+	}
+	return c.PutIfUnmodifiedRaw(&current.Path, bytes.NewReader(value))
+}
+
+// Get the version of an item that was current at the given time. If the item
+// had been deleted by then the result is its tombstone, which can be checked
+// with IsDeleted. ErrNoValueAsOf is returned if the item did not yet exist.
+func (c *Client) GetAsOf(collection, key string, t time.Time) (*RefResult, error) {
+	asOf := uint64(timeToMillis(t))
+
+	// Refs are listed newest first so the first one at or before the time is
+	// the one that was current.
+	iter := c.IterateRefs(collection, key, true, false)
+	for iter.Next() {
+		if iter.Result().RefTime <= asOf {
+			return iter.Result(), nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNoValueAsOf
+}
+
+// Reconstruct the state of a set of items in a collection as it was at the
+// given time. The returned map holds the version of each key that existed at
+// that time; keys that had not been created or had been deleted are left
+// out. The items are fetched concurrently.
+func (c *Client) SnapshotAsOf(collection string, keys []string, t time.Time) (map[string]*RefResult, error) {
+	results := make([]*RefResult, len(keys))
+	errs := make([]error, len(keys))
+	parallel(len(keys), c.concurrency(), func(i int) {
+		results[i], errs[i] = c.GetAsOf(collection, keys[i], t)
+	})
+
+	snapshot := make(map[string]*RefResult, len(keys))
+	for i, key := range keys {
+		if errs[i] == ErrNoValueAsOf || hasStatus(errs[i], 404) {
+			continue
+		} else if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if !results[i].IsDeleted() {
+			snapshot[key] = results[i]
+		}
+	}
+	return snapshot, nil
+}
+
+// Searches the history of an item for a ref, without values.
+func (c *Client) findRef(collection, key, ref string) (*RefResult, error) {
+	iter := c.IterateRefs(collection, key, false, false)
+	for iter.Next() {
+		if iter.Result().Path.Ref == ref {
+			return iter.Result(), nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("Ref not found: %s/%s/refs/%s", collection, key, ref)
+}
+
+// Returns an iterator over every ref of an item, optionally retrieving
+// values. Orchestrate lists refs newest first, so iterating oldest first
+// reads the entire history into memory before the first ref is returned.
+func (c *Client) IterateRefs(collection, key string, values, oldestFirst bool) *RefIterator {
+	return &RefIterator{
+		client:      c,
+		collection:  collection,
+		key:         key,
+		values:      values,
+		oldestFirst: oldestFirst,
+	}
 }
 
 // Execute a ref list operation.
@@ -77,6 +233,9 @@ func (c *Client) doListRefs(trailingUri string) (*RefResults, error) {
 	if err := decoder.Decode(result); err != nil {
 		return result, err
 	}
+	for i := range result.Results {
+		result.Results[i].codec = c.codec()
+	}
 
 	return result, nil
 }
@@ -88,10 +247,99 @@ func (r *RefResults) HasNext() bool {
 
 // Marshall the value of a RefResult into the provided object.
 func (r *RefResult) Value(value interface{}) error {
-	return json.Unmarshal(r.RawValue, value)
+	return decodeValue(r.codec, r.RawValue, value)
+}
+
+// Advance to the next ref, returning false once the history is exhausted or
+// an error occurs.
+func (i *RefIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+
+	if i.page == nil || i.index >= len(i.page.Results) {
+		if !i.fetch() {
+			return false
+		}
+	}
+
+	i.result = &i.page.Results[i.index]
+	i.index++
+	return true
+}
+
+// The ref the iterator is positioned on.
+func (i *RefIterator) Result() *RefResult {
+	return i.result
+}
+
+// Any error encountered while iterating.
+func (i *RefIterator) Err() error {
+	return i.err
+}
+
+// Loads the next page of refs, returning false if there are none.
+func (i *RefIterator) fetch() bool {
+	if i.oldestFirst {
+		// Everything is read on the first fetch.
+		if i.fetched {
+			return false
+		}
+
+		all := &RefResults{}
+		for i.fetchNewestFirst() {
+			all.Results = append(all.Results, i.page.Results...)
+		}
+		if i.err != nil {
+			return false
+		}
+		for l, r := 0, len(all.Results)-1; l < r; l, r = l+1, r-1 {
+			all.Results[l], all.Results[r] = all.Results[r], all.Results[l]
+		}
+		all.Count = uint64(len(all.Results))
+
+		i.page, i.index = all, 0
+		return len(all.Results) > 0
+	}
+
+	return i.fetchNewestFirst()
+}
+
+// Loads the next page of refs in the order Orchestrate returns them.
+func (i *RefIterator) fetchNewestFirst() bool {
+	var page *RefResults
+	var err error
+
+	switch {
+	case !i.fetched:
+		i.fetched = true
+		page, err = i.client.ListRefs(i.collection, i.key, refPageSize, i.values)
+	case i.page.HasNext():
+		i.offset += len(i.page.Results)
+		page, err = i.client.ListRefsGetNext(i.page)
+	case len(i.page.Results) >= refPageSize:
+		// Without a link to the next page we step the offset ourselves.
+		i.offset += len(i.page.Results)
+		page, err = i.client.ListRefsFromOffset(i.collection, i.key, refPageSize, i.values, i.offset)
+	default:
+		return false
+	}
+
+	if err != nil {
+		i.err = err
+		return false
+	}
+
+	i.page, i.index = page, 0
+	return len(page.Results) > 0
 }
 
 // Determines if the given ref represents a deletion.
 func (r *RefResult) IsDeleted() bool {
 	return r.Path.Tombstone
 }
+
+// The time the ref was created.
+func (r *RefResult) Time() time.Time {
+	return millisToTime(int64(r.RefTime))
+}
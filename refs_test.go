@@ -3,12 +3,16 @@
 package gorc
 
 import (
+	"net/http"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 )
 
 func TestRefsHasNext(t *testing.T) {
-	f := func(results *RefResults) bool {
+	f := func(next string) bool {
+		results := &RefResults{Next: next}
 		return !(results.Next == "" && results.HasNext())
 	}
 
@@ -18,7 +22,8 @@ func TestRefsHasNext(t *testing.T) {
 }
 
 func TestRefIsDeleted(t *testing.T) {
-	f := func(result *RefResult) bool {
+	f := func(tombstone bool) bool {
+		result := &RefResult{Path: Path{Tombstone: tombstone}}
 		return !(result.Path.Tombstone == false && result.IsDeleted()) &&
 			!(result.Path.Tombstone == true && !result.IsDeleted())
 	}
@@ -27,3 +32,125 @@ func TestRefIsDeleted(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRestore(t *testing.T) {
+	fake := newFakeOrchestrate()
+	listed := 0
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/refs/") {
+			listed++
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	start := time.Date(2014, 4, 23, 0, 0, 0, 0, time.UTC)
+	first := fake.Write("users", "mary", `{"name":"Mary"}`, start)
+	deleted := fake.Remove("users", "mary", start.Add(time.Hour))
+	current := fake.Write("users", "mary", `{"name":"Mary Jane"}`, start.Add(2*time.Hour))
+
+	// Restoring the current ref changes nothing.
+	if path, err := c.Restore("users", "mary", current); err != nil || path.Ref != current {
+		t.Errorf("Restore() of the current ref = %+v, %v", path, err)
+	} else if fake.Refs("users", "mary") != 3 {
+		t.Error("Restore() of the current ref wrote a new ref")
+	}
+
+	// Restoring a deletion deletes the existing item.
+	if path, err := c.Restore("users", "mary", deleted); err != nil || !path.Tombstone {
+		t.Errorf("Restore() of a deletion = %+v, %v", path, err)
+	} else if _, ok := fake.Value("users", "mary"); ok {
+		t.Error("Restore() of a deletion left the item in place")
+	}
+	if listed != 1 {
+		t.Errorf("Restore() of a deletion listed the history %d times", listed)
+	}
+
+	// Restoring a value onto a deleted item creates it again, without
+	// reading the history.
+	listed = 0
+	if _, err := c.Restore("users", "mary", first); err != nil {
+		t.Fatal(err)
+	} else if value, _ := fake.Value("users", "mary"); value != `{"name":"Mary"}` {
+		t.Errorf("Restored %s", value)
+	}
+	if listed != 0 {
+		t.Errorf("Restore() of a value listed the history %d times", listed)
+	}
+
+	if _, err := c.Restore("users", "mary", "missing"); err == nil {
+		t.Error("Restore() of an unknown ref succeeded")
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.ServeHTTP(w, r)
+
+		// Another writer changes the item straight after Restore reads it.
+		if r.Method == "GET" && r.URL.Path == "/v0/users/mary" {
+			fake.Write("users", "mary", `{"name":"Concurrent"}`, time.Now())
+		}
+	}))
+	defer server.Close()
+
+	first := fake.Write("users", "mary", `{"name":"Mary"}`, time.Now())
+	fake.Write("users", "mary", `{"name":"Mary Jane"}`, time.Now())
+
+	if _, err := c.Restore("users", "mary", first); !hasStatus(err, 412) {
+		t.Errorf("Restore() = %v, expected a 412", err)
+	}
+	if value, _ := fake.Value("users", "mary"); value != `{"name":"Concurrent"}` {
+		t.Errorf("Restore() overwrote a concurrent change with %s", value)
+	}
+}
+
+func TestGetAsOf(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	start := time.Date(2014, 4, 23, 0, 0, 0, 0, time.UTC)
+	first := fake.Write("users", "mary", `{"name":"Mary"}`, start)
+	deleted := fake.Remove("users", "mary", start.Add(2*time.Hour))
+	fake.Write("users", "mary", `{"name":"Mary Jane"}`, start.Add(4*time.Hour))
+
+	if result, err := c.GetAsOf("users", "mary", start.Add(-time.Hour)); err != ErrNoValueAsOf {
+		t.Errorf("GetAsOf() before the first ref = %+v, %v", result, err)
+	}
+
+	result, err := c.GetAsOf("users", "mary", start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value map[string]string
+	if result.Path.Ref != first || result.Value(&value) != nil || value["name"] != "Mary" {
+		t.Errorf("GetAsOf() between refs = %+v", result)
+	}
+
+	result, err = c.GetAsOf("users", "mary", start.Add(3*time.Hour))
+	if err != nil || result.Path.Ref != deleted || !result.IsDeleted() {
+		t.Errorf("GetAsOf() after a deletion = %+v, %v", result, err)
+	}
+}
+
+func TestSnapshotAsOf(t *testing.T) {
+	fake := newFakeOrchestrate()
+	c, server := newTestClient(fake)
+	defer server.Close()
+
+	start := time.Date(2014, 4, 23, 0, 0, 0, 0, time.UTC)
+	fake.Write("users", "mary", `{"name":"Mary"}`, start)
+	fake.Write("users", "bob", `{"name":"Bob"}`, start)
+	fake.Remove("users", "bob", start.Add(time.Hour))
+	fake.Write("users", "late", `{"name":"Late"}`, start.Add(3*time.Hour))
+
+	snapshot, err := c.SnapshotAsOf("users", []string{"mary", "bob", "late", "missing"}, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 1 || snapshot["mary"] == nil {
+		t.Errorf("SnapshotAsOf() = %v, expected only mary", snapshot)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Stores values of a single struct type in a collection. The struct is
+// described with "gorc" field tags:
+//
+//	type User struct {
+//		_     struct{} `gorc:"collection=users"`
+//		ID    string   `gorc:"key" json:"-"`
+//		Ref   string   `gorc:"ref" json:"-"`
+//		Name  string   `json:"name"`
+//	}
+//
+// The key field must be a string and is required. The ref field is
+// optional, when present it is filled in on every load and save and makes
+// saves conditional so that concurrent changes are not overwritten. The
+// collection may be given on any field, or when creating the repository.
+// Both fields are usually excluded from the stored JSON with `json:"-"`.
+type Repository struct {
+	// The collection values are stored in.
+	Collection string
+
+	client   *Client
+	typ      reflect.Type
+	keyField []int
+	refField []int
+}
+
+// Returns a repository for the struct type of prototype, which may be a
+// struct or a pointer to one. If collection is empty the collection is taken
+// from the struct's tags.
+func (c *Client) NewRepository(prototype interface{}, collection string) (*Repository, error) {
+	typ := reflect.TypeOf(prototype)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Repository type must be a struct, not %v", typ)
+	}
+
+	r := &Repository{client: c, typ: typ, Collection: collection}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		for name, value := range parseTag(f.Tag.Get("gorc")) {
+			switch name {
+			case "key":
+				if f.Type.Kind() != reflect.String {
+					return nil, fmt.Errorf("Key field %s.%s must be a string", typ.Name(), f.Name)
+				}
+				r.keyField = f.Index
+			case "ref":
+				if f.Type.Kind() != reflect.String {
+					return nil, fmt.Errorf("Ref field %s.%s must be a string", typ.Name(), f.Name)
+				}
+				r.refField = f.Index
+			case "collection":
+				if r.Collection == "" {
+					r.Collection = value
+				}
+			}
+		}
+	}
+
+	if r.keyField == nil {
+		return nil, fmt.Errorf("%s has no field tagged `gorc:\"key\"`", typ.Name())
+	}
+	if r.Collection == "" {
+		return nil, fmt.Errorf("%s has no collection", typ.Name())
+	}
+	return r, nil
+}
+
+// Load the value stored at key into dst, which must be a pointer to the
+// repository's type.
+func (r *Repository) Load(key string, dst interface{}) error {
+	v, err := r.value(dst)
+	if err != nil {
+		return err
+	} else if key == "" {
+		return fmt.Errorf("Can not load a %s with an empty key", r.typ.Name())
+	}
+
+	result, err := r.client.Get(r.Collection, key)
+	if err != nil {
+		return err
+	}
+	if err := r.client.Decode(result.RawValue, dst); err != nil {
+		return err
+	}
+	r.setPath(v, &result.Path)
+	return nil
+}
+
+// Store src, which must be a pointer to the repository's type. If the ref
+// field is set the save only succeeds if that is still the latest ref, and
+// if it is empty the save only succeeds if nothing is stored at the key yet.
+// Without a ref field the value is stored unconditionally. The ref field is
+// updated with the new ref.
+func (r *Repository) Save(src interface{}) error {
+	v, err := r.value(src)
+	if err != nil {
+		return err
+	}
+
+	key := v.FieldByIndex(r.keyField).String()
+	if key == "" {
+		return fmt.Errorf("Can not save a %s with an empty key", r.typ.Name())
+	}
+
+	var path *Path
+	switch {
+	case r.refField == nil:
+		path, err = r.client.Put(r.Collection, key, src)
+	case v.FieldByIndex(r.refField).String() == "":
+		path, err = r.client.PutIfAbsent(r.Collection, key, src)
+	default:
+		ref := v.FieldByIndex(r.refField).String()
+		path, err = r.client.PutIfUnmodified(&Path{Collection: r.Collection, Key: key, Ref: ref}, src)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.setPath(v, path)
+	return nil
+}
+
+// Delete the stored copy of src, which must be a pointer to the repository's
+// type. If the ref field is set the delete only succeeds if that is still
+// the latest ref.
+func (r *Repository) Delete(src interface{}) error {
+	v, err := r.value(src)
+	if err != nil {
+		return err
+	}
+
+	key := v.FieldByIndex(r.keyField).String()
+	if key == "" {
+		return fmt.Errorf("Can not delete a %s with an empty key", r.typ.Name())
+	}
+
+	if r.refField != nil {
+		if ref := v.FieldByIndex(r.refField).String(); ref != "" {
+			return r.client.DeleteIfUnmodified(&Path{Collection: r.Collection, Key: key, Ref: ref})
+		}
+	}
+	return r.client.Delete(r.Collection, key)
+}
+
+// Search the collection, storing the matching values in dst, which must be a
+// pointer to a slice of the repository's type or of pointers to it. The
+// search results are returned for paging.
+func (r *Repository) Find(query string, limit, offset int, dst interface{}) (*SearchResults, error) {
+	results, err := r.client.Search(r.Collection, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.fill(dst, len(results.Results), func(i int, elem interface{}) (*Path, error) {
+		return &results.Results[i].Path, r.client.Decode(results.Results[i].RawValue, elem)
+	})
+	return results, err
+}
+
+// List the first limit values of the collection in key order, storing them
+// in dst, which must be a pointer to a slice of the repository's type or of
+// pointers to it. The list results are returned for paging.
+func (r *Repository) List(limit int, dst interface{}) (*KVResults, error) {
+	results, err := r.client.List(r.Collection, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.fill(dst, len(results.Results), func(i int, elem interface{}) (*Path, error) {
+		return &results.Results[i].Path, r.client.Decode(results.Results[i].RawValue, elem)
+	})
+	return results, err
+}
+
+// Fills the slice pointed to by dst with n values, each decoded by decode.
+func (r *Repository) fill(dst interface{}, n int, decode func(i int, elem interface{}) (*Path, error)) error {
+	slice := reflect.ValueOf(dst)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Expected a pointer to a slice of %s, not %T", r.typ.Name(), dst)
+	}
+	slice = slice.Elem()
+
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if (isPtr && elemType.Elem() != r.typ) || (!isPtr && elemType != r.typ) {
+		return fmt.Errorf("Expected a pointer to a slice of %s, not %T", r.typ.Name(), dst)
+	}
+
+	values := reflect.MakeSlice(slice.Type(), n, n)
+	for i := 0; i < n; i++ {
+		elem := reflect.New(r.typ)
+		path, err := decode(i, elem.Interface())
+		if err != nil {
+			return err
+		}
+		r.setPath(elem.Elem(), path)
+
+		if isPtr {
+			values.Index(i).Set(elem)
+		} else {
+			values.Index(i).Set(elem.Elem())
+		}
+	}
+
+	slice.Set(values)
+	return nil
+}
+
+// Returns the struct pointed to by ptr, checking it is the repository's type.
+func (r *Repository) value(ptr interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Type() != r.typ {
+		return reflect.Value{}, fmt.Errorf("Expected a *%s, not %T", r.typ.Name(), ptr)
+	}
+	return v.Elem(), nil
+}
+
+// Copies the key and ref of a path into a struct.
+func (r *Repository) setPath(v reflect.Value, path *Path) {
+	v.FieldByIndex(r.keyField).SetString(path.Key)
+	if r.refField != nil {
+		v.FieldByIndex(r.refField).SetString(path.Ref)
+	}
+}
+
+// Parses a "gorc" struct tag into its comma separated options. Options may
+// carry a value, as in "collection=users".
+func parseTag(tag string) map[string]string {
+	options := make(map[string]string)
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if i := strings.Index(option, "="); i >= 0 {
+			options[option[:i]] = option[i+1:]
+		} else {
+			options[option] = ""
+		}
+	}
+	return options
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+type repositoryUser struct {
+	_    struct{} `gorc:"collection=users"`
+	ID   string   `gorc:"key" json:"-"`
+	Ref  string   `gorc:"ref" json:"-"`
+	Name string   `json:"name"`
+}
+
+func TestRepositoryTags(t *testing.T) {
+	c := NewClient("")
+
+	r, err := c.NewRepository(&repositoryUser{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Collection != "users" {
+		t.Errorf("Collection = %q, expected \"users\"", r.Collection)
+	}
+
+	if r, err := c.NewRepository(repositoryUser{}, "people"); err != nil || r.Collection != "people" {
+		t.Errorf("NewRepository did not use the given collection: %v, %v", r, err)
+	}
+
+	type noKey struct {
+		Name string `gorc:"collection=users"`
+	}
+	if _, err := c.NewRepository(noKey{}, ""); err == nil {
+		t.Error("NewRepository accepted a struct without a key")
+	}
+}
+
+func TestRepositoryFill(t *testing.T) {
+	r, err := NewClient("").NewRepository(&repositoryUser{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []KVResult{
+		{Path: Path{Collection: "users", Key: "a", Ref: "1"}, RawValue: json.RawMessage(`{"name": "Al"}`)},
+		{Path: Path{Collection: "users", Key: "b", Ref: "2"}, RawValue: json.RawMessage(`{"name": "Bo"}`)},
+	}
+	decode := func(i int, elem interface{}) (*Path, error) {
+		return &results[i].Path, results[i].Value(elem)
+	}
+
+	var users []repositoryUser
+	if err := r.fill(&users, len(results), decode); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].ID != "b" || users[1].Ref != "2" || users[1].Name != "Bo" {
+		t.Errorf("fill() produced %+v", users)
+	}
+
+	var pointers []*repositoryUser
+	if err := r.fill(&pointers, len(results), decode); err != nil {
+		t.Fatal(err)
+	}
+	if len(pointers) != 2 || pointers[0].ID != "a" || pointers[0].Name != "Al" {
+		t.Errorf("fill() produced %+v", pointers)
+	}
+
+	var wrong []string
+	if err := r.fill(&wrong, len(results), decode); err == nil {
+		t.Error("fill() accepted a slice of the wrong type")
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	options := parseTag("key, collection=users,encrypt")
+	if len(options) != 3 || options["collection"] != "users" {
+		t.Errorf("parseTag() = %v", options)
+	}
+	if _, ok := options["key"]; !ok {
+		t.Errorf("parseTag() = %v, missing key", options)
+	}
+}
+
+func TestRepositorySaveLoad(t *testing.T) {
+	fake := newFakeOrchestrate()
+	var requests []string
+	c, server := newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("If-None-Match")+r.Header.Get("If-Match"))
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	r, err := c.NewRepository(&repositoryUser{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a ref the save only creates.
+	user := &repositoryUser{ID: "mary", Name: "Mary"}
+	if err := r.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	created := user.Ref
+	if created == "" {
+		t.Error("Save() did not fill in the ref")
+	}
+
+	// With a ref the save only replaces that ref.
+	user.Name = "Mary Jane"
+	if err := r.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	stale := &repositoryUser{ID: "mary", Ref: created, Name: "Stale"}
+	if err := r.Save(stale); !hasStatus(err, 412) {
+		t.Errorf("Save() with a stale ref = %v", err)
+	}
+
+	loaded := new(repositoryUser)
+	if err := r.Load("mary", loaded); err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *user {
+		t.Errorf("Load() = %+v, expected %+v", loaded, user)
+	}
+
+	// Without a ref field saves are unconditional.
+	type plainUser struct {
+		ID   string `gorc:"key,collection=users" json:"-"`
+		Name string `json:"name"`
+	}
+	plain, err := c.NewRepository(plainUser{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Save(&plainUser{ID: "mary", Name: "Plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`PUT /v0/users/mary "*"`,
+		`PUT /v0/users/mary "` + created + `"`,
+		`PUT /v0/users/mary "` + created + `"`,
+		`GET /v0/users/mary `,
+		`PUT /v0/users/mary `,
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("Requests %q, expected %q", requests, expected)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("Request %d = %q, expected %q", i, requests[i], expected[i])
+		}
+	}
+
+	// Nothing is sent for an empty key.
+	if err := r.Delete(&repositoryUser{}); err == nil {
+		t.Error("Delete() accepted an empty key")
+	}
+	if err := r.Load("", loaded); err == nil {
+		t.Error("Load() accepted an empty key")
+	}
+	if len(requests) != len(expected) {
+		t.Errorf("Requests %q sent for an empty key", requests[len(expected):])
+	}
+}
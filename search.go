@@ -35,6 +35,9 @@ type SearchResult struct {
 	Score    float64         `json:"score"`
 	Distance float64         `json:"distance"`
 	RawValue json.RawMessage `json:"value"`
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
 }
 
 // Search a collection with a Lucene Query Parser Syntax Query
@@ -78,12 +81,12 @@ func (c *Client) SearchSorted(
 
 // Get the page of search results that follow that provided set.
 func (c *Client) SearchGetNext(results *SearchResults) (*SearchResults, error) {
-	return c.doSearch(results.Next[4:])
+	return c.doSearch(trailingFromLink(results.Next))
 }
 
 // Get the page of search results that precede that provided set.
 func (c *Client) SearchGetPrev(results *SearchResults) (*SearchResults, error) {
-	return c.doSearch(results.Prev[4:])
+	return c.doSearch(trailingFromLink(results.Prev))
 }
 
 // Execute a search request.
@@ -106,6 +109,9 @@ func (c *Client) doSearch(trailingUri string) (*SearchResults, error) {
 	if err := decoder.Decode(result); err != nil {
 		return result, err
 	}
+	for i := range result.Results {
+		result.Results[i].codec = c.codec()
+	}
 
 	return result, nil
 }
@@ -122,5 +128,5 @@ func (r *SearchResults) HasPrev() bool {
 
 // Marshall the value of a SearchResult into the provided object.
 func (r *SearchResult) Value(value interface{}) error {
-	return json.Unmarshal(r.RawValue, value)
+	return decodeValue(r.codec, r.RawValue, value)
 }
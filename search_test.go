@@ -20,7 +20,8 @@ import (
 )
 
 func TestSearchHasNext(t *testing.T) {
-	f := func(results *SearchResults) bool {
+	f := func(next string) bool {
+		results := &SearchResults{Next: next}
 		return !(results.Next == "" && results.HasNext())
 	}
 
@@ -30,7 +31,8 @@ func TestSearchHasNext(t *testing.T) {
 }
 
 func TestSearchHasPrev(t *testing.T) {
-	f := func(results *SearchResults) bool {
+	f := func(prev string) bool {
+		results := &SearchResults{Prev: prev}
 		return !(results.Prev == "" && results.HasPrev())
 	}
 
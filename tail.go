@@ -0,0 +1,252 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stores the position of an event tail so that it can resume where it left
+// off after a restart.
+type TailCheckpoint interface {
+	// Load the last delivered event for a collection-key pair and event type.
+	// This returns nil if nothing has been saved.
+	Load(collection, key, kind string) (*EventBound, error)
+
+	// Save the last delivered event for a collection-key pair and event type.
+	Save(collection, key, kind string, cursor *EventBound) error
+}
+
+// Options that control how an event tail polls.
+type TailOptions struct {
+	// Where to store the tail's position. If nil the position is only kept
+	// in memory and the tail starts from the given time every time.
+	Checkpoint TailCheckpoint
+
+	// The delay between polls while events are arriving. Defaults to one
+	// second.
+	MinInterval time.Duration
+
+	// The longest delay between polls. Each poll that finds no new events
+	// doubles the delay up to this limit. Defaults to thirty seconds.
+	MaxInterval time.Duration
+
+	// The number of events buffered in the Events channel.
+	Buffer int
+}
+
+// A subscription to the events of a particular type on a collection-key pair.
+// Events are delivered on the Events channel in timestamp and ordinal order,
+// each exactly once, until Stop is called or an error occurs.
+type EventTail struct {
+	// Receives each new event. This is closed when the tail stops.
+	Events <-chan *Event
+
+	client     *Client
+	collection string
+	key        string
+	kind       string
+	opts       TailOptions
+	cursor     *EventBound
+
+	events chan *Event
+	stop   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// Follow the events of a particular type on a collection-key pair, starting
+// with those at or after since. If the options hold a checkpoint with a
+// saved position then the tail resumes after it instead.
+func (c *Client) TailEvents(collection, key, kind string, since time.Time, opts *TailOptions) *EventTail {
+	t := &EventTail{
+		client:     c,
+		collection: collection,
+		key:        key,
+		kind:       kind,
+		stop:       make(chan struct{}),
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.MinInterval <= 0 {
+		t.opts.MinInterval = time.Second
+	}
+	if t.opts.MaxInterval < t.opts.MinInterval {
+		t.opts.MaxInterval = 30 * time.Second
+		if t.opts.MaxInterval < t.opts.MinInterval {
+			t.opts.MaxInterval = t.opts.MinInterval
+		}
+	}
+
+	t.events = make(chan *Event, t.opts.Buffer)
+	t.Events = t.events
+
+	go t.run(since)
+	return t
+}
+
+// Stop the tail. The Events channel is closed once any in progress poll
+// completes.
+func (t *EventTail) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// The error that stopped the tail, if any. This should be checked once the
+// Events channel has been closed.
+func (t *EventTail) Err() error {
+	return t.err
+}
+
+// Polls for events until stopped.
+func (t *EventTail) run(since time.Time) {
+	defer close(t.events)
+
+	if t.opts.Checkpoint != nil {
+		cursor, err := t.opts.Checkpoint.Load(t.collection, t.key, t.kind)
+		if err != nil {
+			t.err = err
+			return
+		}
+		t.cursor = cursor
+	}
+
+	start := EventBoundAt(since)
+	interval := t.opts.MinInterval
+	for {
+		query := &EventRange{Ascending: true}
+		if t.cursor != nil {
+			query.After = t.cursor
+		} else {
+			query.Start = start
+		}
+
+		delivered, err := t.poll(query)
+		if err != nil {
+			t.err = err
+			return
+		}
+
+		if delivered {
+			interval = t.opts.MinInterval
+		} else if interval *= 2; interval > t.opts.MaxInterval {
+			interval = t.opts.MaxInterval
+		}
+
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Delivers every event in the query, returning true if there were any. The
+// cursor is advanced and saved after each event is handed off.
+func (t *EventTail) poll(query *EventRange) (bool, error) {
+	delivered := false
+
+	iter := t.client.IterateEvents(t.collection, t.key, t.kind, query)
+	for iter.Next() {
+		event := iter.Event()
+		select {
+		case <-t.stop:
+			return delivered, nil
+		case t.events <- event:
+		}
+		delivered = true
+
+		t.cursor = &EventBound{Timestamp: int64(event.Timestamp), Ordinal: event.Ordinal}
+		if t.opts.Checkpoint != nil {
+			err := t.opts.Checkpoint.Save(t.collection, t.key, t.kind, t.cursor)
+			if err != nil {
+				return delivered, err
+			}
+		}
+	}
+
+	return delivered, iter.Err()
+}
+
+// A TailCheckpoint that keeps positions in memory. This allows several tails
+// in one process to share their positions, or a tail to be restarted.
+type MemoryCheckpoint struct {
+	lock    sync.Mutex
+	cursors map[string]EventBound
+}
+
+// Load the last delivered event.
+func (m *MemoryCheckpoint) Load(collection, key, kind string) (*EventBound, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if cursor, ok := m.cursors[collection+"/"+key+"/"+kind]; ok {
+		return &cursor, nil
+	}
+	return nil, nil
+}
+
+// Save the last delivered event.
+func (m *MemoryCheckpoint) Save(collection, key, kind string, cursor *EventBound) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.cursors == nil {
+		m.cursors = make(map[string]EventBound)
+	}
+	m.cursors[collection+"/"+key+"/"+kind] = *cursor
+	return nil
+}
+
+// A TailCheckpoint that stores positions as items in an Orchestrate
+// collection, so a tail can resume after its process restarts.
+type KVCheckpoint struct {
+	Client *Client
+
+	// The collection positions are stored in.
+	Collection string
+}
+
+// Load the last delivered event.
+func (k *KVCheckpoint) Load(collection, key, kind string) (*EventBound, error) {
+	result, err := k.Client.Get(k.Collection, k.key(collection, key, kind))
+	if hasStatus(err, 404) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cursor := new(EventBound)
+	if err := k.Client.Decode(result.RawValue, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// Save the last delivered event.
+func (k *KVCheckpoint) Save(collection, key, kind string, cursor *EventBound) error {
+	_, err := k.Client.Put(k.Collection, k.key(collection, key, kind), cursor)
+	return err
+}
+
+// Returns the key a position is stored under. The collection and key are
+// prefixed with their lengths so that names containing the separator can
+// not share a position, as "a_b", "c" and "a", "b_c" otherwise would.
+func (k *KVCheckpoint) key(collection, key, kind string) string {
+	return strconv.Itoa(len(collection)) + "_" + collection + "_" +
+		strconv.Itoa(len(key)) + "_" + key + "_" + kind
+}
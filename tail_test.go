@@ -0,0 +1,156 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Receives count events from a tail, failing if they do not arrive.
+func receiveEvents(t *testing.T, tail *EventTail, count int) []string {
+	var values []string
+	for len(values) < count {
+		select {
+		case event, ok := <-tail.Events:
+			if !ok {
+				t.Fatalf("Tail stopped after %v: %v", values, tail.Err())
+			}
+			var value string
+			event.Value(&value)
+			values = append(values, value)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out after receiving %v", values)
+		}
+	}
+	return values
+}
+
+// Stops a tail and returns anything it delivered in the meantime.
+func stopTail(t *testing.T, tail *EventTail) []string {
+	tail.Stop()
+	var extra []string
+	for event := range tail.Events {
+		extra = append(extra, string(event.RawValue))
+	}
+	if err := tail.Err(); err != nil {
+		t.Error(err)
+	}
+	return extra
+}
+
+func TestTailEventsDeliversOnce(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	for _, value := range []string{"a", "b", "c"} {
+		c.PostEvent("users", "mary", "login", value)
+	}
+
+	opts := &TailOptions{MinInterval: 5 * time.Millisecond, MaxInterval: 10 * time.Millisecond}
+	tail := c.TailEvents("users", "mary", "login", time.Unix(0, 0), opts)
+
+	if values := receiveEvents(t, tail, 3); strings.Join(values, "") != "abc" {
+		t.Errorf("Received %v, expected a, b, c", values)
+	}
+
+	// Let the tail poll a few times with nothing new before adding more.
+	time.Sleep(30 * time.Millisecond)
+	c.PostEvent("users", "mary", "login", "d")
+	c.PostEvent("users", "mary", "login", "e")
+	if values := receiveEvents(t, tail, 2); strings.Join(values, "") != "de" {
+		t.Errorf("Received %v, expected d, e", values)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if extra := stopTail(t, tail); len(extra) != 0 {
+		t.Errorf("Received duplicates %v", extra)
+	}
+}
+
+func TestTailEventsResumesFromCheckpoint(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	checkpoint := &KVCheckpoint{Client: c, Collection: "checkpoints"}
+	opts := &TailOptions{Checkpoint: checkpoint, MinInterval: 5 * time.Millisecond}
+
+	c.PostEvent("users", "mary", "login", "a")
+	c.PostEvent("users", "mary", "login", "b")
+
+	tail := c.TailEvents("users", "mary", "login", time.Unix(0, 0), opts)
+	received := receiveEvents(t, tail, 2)
+	received = append(received, stopTail(t, tail)...)
+	if strings.Join(received, "") != "ab" {
+		t.Fatalf("Received %v, expected a, b", received)
+	}
+
+	c.PostEvent("users", "mary", "login", "c")
+
+	// The saved position wins over the start time, which is after every
+	// event.
+	tail = c.TailEvents("users", "mary", "login", time.Now().Add(time.Hour), opts)
+	if values := receiveEvents(t, tail, 1); values[0] != "c" {
+		t.Errorf("Resumed with %v, expected c", values)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if extra := stopTail(t, tail); len(extra) != 0 {
+		t.Errorf("Received duplicates %v", extra)
+	}
+}
+
+func TestMemoryCheckpoint(t *testing.T) {
+	checkpoint := &MemoryCheckpoint{}
+	if cursor, err := checkpoint.Load("users", "mary", "login"); cursor != nil || err != nil {
+		t.Errorf("Load() before Save() = %v, %v", cursor, err)
+	}
+
+	saved := &EventBound{Timestamp: 1398286518286, Ordinal: 4}
+	checkpoint.Save("users", "mary", "login", saved)
+	saved.Ordinal = 5
+
+	cursor, err := checkpoint.Load("users", "mary", "login")
+	if err != nil || cursor == nil || *cursor != (EventBound{Timestamp: 1398286518286, Ordinal: 4}) {
+		t.Errorf("Load() = %v, %v", cursor, err)
+	}
+	if cursor, _ := checkpoint.Load("users", "mary", "logout"); cursor != nil {
+		t.Errorf("Load() of another type = %v", cursor)
+	}
+}
+
+func TestKVCheckpointKeepsNamesApart(t *testing.T) {
+	c, server := newTestClient(newFakeOrchestrate())
+	defer server.Close()
+
+	checkpoint := &KVCheckpoint{Client: c, Collection: "checkpoints"}
+	if err := checkpoint.Save("a_b", "c", "login", &EventBound{Timestamp: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.Save("a", "b_c", "login", &EventBound{Timestamp: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		collection, key string
+		timestamp       int64
+	}{{"a_b", "c", 1}, {"a", "b_c", 2}} {
+		cursor, err := checkpoint.Load(test.collection, test.key, "login")
+		if err != nil || cursor == nil || cursor.Timestamp != test.timestamp {
+			t.Errorf("Load(%q, %q) = %v, %v, expected timestamp %d",
+				test.collection, test.key, cursor, err, test.timestamp)
+		}
+	}
+}
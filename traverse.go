@@ -0,0 +1,363 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Returned by a traversal visitor to end the traversal early. Traverse then
+// returns nil rather than this error.
+var ErrStopTraversal = errors.New("gorc: stop traversal")
+
+// The order nodes are visited in during a traversal.
+type TraversalOrder int
+
+const (
+	// Visit every node at one depth before any node at the next.
+	BreadthFirst TraversalOrder = iota
+
+	// Follow each relationship as deep as possible before backtracking.
+	DepthFirst
+)
+
+// Describes how a traversal walks the graph.
+type Traversal struct {
+	// The relation types followed from every node.
+	Kinds []string
+
+	// The maximum number of hops from the start nodes. Zero means there is no
+	// limit, which on a large graph may visit a great many nodes.
+	MaxDepth int
+
+	// The order nodes are visited in.
+	Order TraversalOrder
+
+	// Decides whether a node is part of the traversal. Nodes it rejects are
+	// neither visited nor expanded. If nil every node is accepted.
+	Filter func(node *TraversalNode) bool
+
+	// Called for every relationship followed between accepted nodes,
+	// including those leading back to nodes that were already visited.
+	Edge func(from, to *TraversalNode, kind string)
+
+	// The number of nodes expanded at once during a breadth first traversal.
+	// If zero the client's concurrency is used.
+	Concurrency int
+}
+
+// A node reached during a traversal.
+type TraversalNode struct {
+	Path     Path
+	RawValue json.RawMessage
+
+	// The number of hops from the start node. During a depth first
+	// traversal a node may be reached again by a shorter route after it was
+	// visited, in which case this and Parent are updated to that route.
+	Depth int
+
+	// The node this one was first reached from, and the relation type that
+	// was followed. These are empty for start nodes.
+	Parent *TraversalNode
+	Kind   string
+
+	// The codec used by Value, set by the client that fetched the result.
+	codec Codec
+}
+
+// A relationship found while expanding a node.
+type traversalEdge struct {
+	kind   string
+	result GraphResult
+}
+
+// The state of a single traversal.
+type traversal struct {
+	client  *Client
+	t       *Traversal
+	visit   func(node *TraversalNode) error
+	visited map[string]*TraversalNode
+
+	// The relationships of each node expanded during a depth first
+	// traversal, kept so that a node reached again by a shorter route can
+	// be expanded further without fetching them twice.
+	edges map[string][]traversalEdge
+}
+
+// Walk the graph from the given start nodes, calling visit once for every
+// node reached. Each node is visited at most once no matter how many
+// relationships lead to it, so cycles are safe.
+func (c *Client) Traverse(starts []Path, t *Traversal, visit func(node *TraversalNode) error) error {
+	tr := &traversal{
+		client:  c,
+		t:       t,
+		visit:   visit,
+		visited: make(map[string]*TraversalNode),
+		edges:   make(map[string][]traversalEdge),
+	}
+
+	var roots []*TraversalNode
+	for _, start := range starts {
+		node := &TraversalNode{
+			Path:  Path{Collection: start.Collection, Key: start.Key},
+			codec: c.codec(),
+		}
+		if _, ok := tr.visited[nodeID(&node.Path)]; ok {
+			continue
+		}
+
+		result, err := c.Get(start.Collection, start.Key)
+		if err != nil && !hasStatus(err, 404) {
+			return err
+		} else if err == nil {
+			node.Path = result.Path
+			node.RawValue = result.RawValue
+		}
+
+		if tr.accept(node) {
+			roots = append(roots, node)
+		}
+	}
+
+	var err error
+	if t.Order == DepthFirst {
+		for _, root := range roots {
+			if err = tr.depthFirst(root); err != nil {
+				break
+			}
+		}
+	} else {
+		err = tr.breadthFirst(roots)
+	}
+
+	if err == ErrStopTraversal {
+		return nil
+	}
+	return err
+}
+
+// Find the shortest chain of relationships from one item to another,
+// following the given relation types up to maxDepth hops. The chain starts
+// with from and ends with to, or is nil if to could not be reached.
+func (c *Client) ShortestPath(from, to Path, kinds []string, maxDepth int) ([]*TraversalNode, error) {
+	target := nodeID(&to)
+
+	var found *TraversalNode
+	t := &Traversal{Kinds: kinds, MaxDepth: maxDepth}
+	err := c.Traverse([]Path{from}, t, func(node *TraversalNode) error {
+		if nodeID(&node.Path) == target {
+			found = node
+			return ErrStopTraversal
+		}
+		return nil
+	})
+	if err != nil || found == nil {
+		return nil, err
+	}
+
+	return found.Chain(), nil
+}
+
+// Visits nodes one level at a time, expanding each level concurrently.
+func (tr *traversal) breadthFirst(frontier []*TraversalNode) error {
+	concurrency := tr.t.Concurrency
+	if concurrency <= 0 {
+		concurrency = tr.client.concurrency()
+	}
+
+	for len(frontier) > 0 {
+		for _, node := range frontier {
+			if err := tr.visit(node); err != nil {
+				return err
+			}
+		}
+
+		if tr.atMaxDepth(frontier[0]) {
+			return nil
+		}
+
+		edges := make([][]traversalEdge, len(frontier))
+		errs := make([]error, len(frontier))
+		parallel(len(frontier), concurrency, func(i int) {
+			edges[i], errs[i] = tr.expand(frontier[i])
+		})
+
+		var next []*TraversalNode
+		for i, node := range frontier {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			next = append(next, tr.follow(node, edges[i])...)
+		}
+		frontier = next
+	}
+
+	return nil
+}
+
+// Visits a node and then everything reachable from it that has not already
+// been visited.
+func (tr *traversal) depthFirst(node *TraversalNode) error {
+	if err := tr.visit(node); err != nil {
+		return err
+	}
+	return tr.descend(node)
+}
+
+// Follows the relationships of a visited node, visiting each node reached
+// for the first time. Nodes that were already visited are descended into
+// again if this route to them is shorter, since with a MaxDepth the longer
+// route may have stopped short of nodes within reach of the shorter one.
+func (tr *traversal) descend(node *TraversalNode) error {
+	if tr.atMaxDepth(node) {
+		return nil
+	}
+
+	id := nodeID(&node.Path)
+	edges, expanded := tr.edges[id]
+	if !expanded {
+		var err error
+		if edges, err = tr.expand(node); err != nil {
+			return err
+		}
+		tr.edges[id] = edges
+	}
+
+	for _, edge := range edges {
+		child, seen := tr.visited[nodeID(&edge.result.Path)]
+		if !seen {
+			child = &TraversalNode{
+				Path:     edge.result.Path,
+				RawValue: edge.result.RawValue,
+				Depth:    node.Depth + 1,
+				Parent:   node,
+				Kind:     edge.kind,
+				codec:    tr.client.codec(),
+			}
+			if !tr.accept(child) {
+				continue
+			}
+		} else if child == nil {
+			continue
+		}
+
+		// Relationships are only reported the first time a node is expanded.
+		if !expanded && tr.t.Edge != nil {
+			tr.t.Edge(node, child, edge.kind)
+		}
+
+		if !seen {
+			if err := tr.depthFirst(child); err != nil {
+				return err
+			}
+		} else if child.Depth > node.Depth+1 {
+			child.Depth = node.Depth + 1
+			child.Parent = node
+			child.Kind = edge.kind
+			if err := tr.descend(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Fetches every relationship of the followed types from a node.
+func (tr *traversal) expand(node *TraversalNode) ([]traversalEdge, error) {
+	var edges []traversalEdge
+	for _, kind := range tr.t.Kinds {
+		iter := tr.client.IterateRelations(node.Path.Collection, node.Path.Key, []string{kind}, 100)
+		for iter.Next() {
+			edges = append(edges, traversalEdge{kind: kind, result: *iter.Result()})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return edges, nil
+}
+
+// Records the relationships of a node, returning the nodes reached for the
+// first time.
+func (tr *traversal) follow(node *TraversalNode, edges []traversalEdge) []*TraversalNode {
+	var reached []*TraversalNode
+	for _, edge := range edges {
+		id := nodeID(&edge.result.Path)
+
+		child, seen := tr.visited[id]
+		if !seen {
+			child = &TraversalNode{
+				Path:     edge.result.Path,
+				RawValue: edge.result.RawValue,
+				Depth:    node.Depth + 1,
+				Parent:   node,
+				Kind:     edge.kind,
+				codec:    tr.client.codec(),
+			}
+			if tr.accept(child) {
+				reached = append(reached, child)
+			} else {
+				child = nil
+			}
+		}
+
+		if child != nil && tr.t.Edge != nil {
+			tr.t.Edge(node, child, edge.kind)
+		}
+	}
+	return reached
+}
+
+// Runs the filter over a newly reached node and marks it as seen. Rejected
+// nodes are remembered so the filter only runs once per node.
+func (tr *traversal) accept(node *TraversalNode) bool {
+	id := nodeID(&node.Path)
+	if tr.t.Filter != nil && !tr.t.Filter(node) {
+		tr.visited[id] = nil
+		return false
+	}
+	tr.visited[id] = node
+	return true
+}
+
+// Returns true if nodes beyond this one should not be expanded.
+func (tr *traversal) atMaxDepth(node *TraversalNode) bool {
+	return tr.t.MaxDepth > 0 && node.Depth >= tr.t.MaxDepth
+}
+
+// Returns the identity of the item at a path.
+func nodeID(path *Path) string {
+	return path.Collection + "/" + path.Key
+}
+
+// Returns the chain of nodes leading from the start of the traversal to this
+// node, following the relationships through which each node was first
+// reached.
+func (n *TraversalNode) Chain() []*TraversalNode {
+	var chain []*TraversalNode
+	for node := n; node != nil; node = node.Parent {
+		chain = append(chain, node)
+	}
+	for l, r := 0, len(chain)-1; l < r; l, r = l+1, r-1 {
+		chain[l], chain[r] = chain[r], chain[l]
+	}
+	return chain
+}
+
+// Marshall the value of a node into the provided object.
+func (n *TraversalNode) Value(value interface{}) error {
+	return decodeValue(n.codec, n.RawValue, value)
+}
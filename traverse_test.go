@@ -0,0 +1,115 @@
+// Copyright 2014 Orchestrate, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gorc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTraversalNodeChain(t *testing.T) {
+	a := &TraversalNode{Path: Path{Collection: "users", Key: "a"}}
+	b := &TraversalNode{Path: Path{Collection: "users", Key: "b"}, Depth: 1, Parent: a, Kind: "friend"}
+	c := &TraversalNode{Path: Path{Collection: "users", Key: "c"}, Depth: 2, Parent: b, Kind: "friend"}
+
+	chain := c.Chain()
+	if len(chain) != 3 || chain[0] != a || chain[1] != b || chain[2] != c {
+		t.Errorf("Chain() returned the wrong nodes: %v", chain)
+	}
+	if chain := a.Chain(); len(chain) != 1 || chain[0] != a {
+		t.Errorf("Chain() of a start node returned: %v", chain)
+	}
+}
+
+// Returns a client for a server holding a graph of "friend" relations
+// between users, given as each key's destinations. Items themselves do not
+// exist.
+func newGraphClient(graph map[string][]string) (*Client, *httptest.Server) {
+	return newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+		if len(parts) != 4 || parts[2] != "relations" || parts[3] != "friend" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message": "not found"}`))
+			return
+		}
+
+		results := &GraphResults{Results: []GraphResult{}}
+		for _, key := range graph[parts[1]] {
+			results.Results = append(results.Results, GraphResult{
+				Path:     Path{Collection: "users", Key: key},
+				RawValue: json.RawMessage(`{}`),
+			})
+		}
+		results.Count = uint64(len(results.Results))
+		json.NewEncoder(w).Encode(results)
+	}))
+}
+
+func TestTraverseMaxDepth(t *testing.T) {
+	// F is three hops from A through C, but the first route found to E, and
+	// so to F, is through B and D.
+	graph := map[string][]string{
+		"a": {"b", "c"},
+		"b": {"d"},
+		"c": {"e"},
+		"d": {"e"},
+		"e": {"f"},
+	}
+	c, server := newGraphClient(graph)
+	defer server.Close()
+
+	for _, order := range []TraversalOrder{BreadthFirst, DepthFirst} {
+		visited := map[string]*TraversalNode{}
+		edges := 0
+		traversal := &Traversal{
+			Kinds:    []string{"friend"},
+			MaxDepth: 3,
+			Order:    order,
+			Edge:     func(from, to *TraversalNode, kind string) { edges++ },
+		}
+		err := c.Traverse([]Path{{Collection: "users", Key: "a"}}, traversal, func(node *TraversalNode) error {
+			if _, ok := visited[node.Path.Key]; ok {
+				t.Errorf("Order %d: visited %s twice", order, node.Path.Key)
+			}
+			visited[node.Path.Key] = node
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var keys []string
+		for key := range visited {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, "") != "abcdef" {
+			t.Errorf("Order %d: visited %v", order, keys)
+		}
+		if e := visited["e"]; e == nil || e.Depth != 2 || e.Parent.Path.Key != "c" {
+			t.Errorf("Order %d: e was not given its shortest route: %+v", order, e)
+		}
+		if f := visited["f"]; f != nil && f.Depth != 3 {
+			t.Errorf("Order %d: f has depth %d", order, f.Depth)
+		}
+		if edges != 6 {
+			t.Errorf("Order %d: reported %d edges, expected 6", order, edges)
+		}
+	}
+}